@@ -15,10 +15,11 @@ import (
 
 var (
 	// Download from https://github.com/ekzhu/set-similarity-search-benchmarks
-	allPairsContainmentBenchmarkFilename  = "canada_us_uk_opendata.inp.gz"
-	allPairsContainmentBenchmarkResult    = "canada_us_uk_opendata_all_pairs_containment.csv"
-	allPairsContainmentBenchmarkThreshold = 0.9
-	allPairsContainmentBenchmarkMinSize   = 10
+	allPairsContainmentBenchmarkFilename   = "canada_us_uk_opendata.inp.gz"
+	allPairsContainmentBenchmarkResult     = "canada_us_uk_opendata_all_pairs_containment.csv"
+	allPairsContainmentJoinBenchmarkResult = "canada_us_uk_opendata_all_pairs_containment_join.csv"
+	allPairsContainmentBenchmarkThreshold  = 0.9
+	allPairsContainmentBenchmarkMinSize    = 10
 )
 
 // Read set similarity search benchmark files from
@@ -100,8 +101,7 @@ func BenchmarkOpenDataAllPairContainment(b *testing.B) {
 	log.Printf("Begin querying")
 	start = time.Now()
 	var count int
-	for i, set := range sets {
-		results := searchIndex.Query(set)
+	for i, results := range searchIndex.QueryBatch(sets, 0) {
 		for _, result := range results {
 			if result.X == i {
 				continue
@@ -114,7 +114,7 @@ func BenchmarkOpenDataAllPairContainment(b *testing.B) {
 		}
 		count++
 		if count%100 == 0 {
-			fmt.Printf("\rQueried %d sets so far", count)
+			fmt.Printf("\rWrote results of %d queries so far", count)
 		}
 	}
 	fmt.Println()
@@ -125,3 +125,10 @@ func BenchmarkOpenDataAllPairContainment(b *testing.B) {
 	}
 	log.Printf("Results written to %s", allPairsContainmentBenchmarkResult)
 }
+
+func BenchmarkOpenDataAllPairContainmentJoin(b *testing.B) {
+	benchmarkAllPairRowFile(b, allPairsContainmentBenchmarkFilename,
+		allPairsContainmentJoinBenchmarkResult, "containment",
+		allPairsContainmentBenchmarkThreshold,
+		allPairsContainmentBenchmarkMinSize)
+}
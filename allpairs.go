@@ -1,8 +1,12 @@
 package SetSimilaritySearch
 
 import (
+	"container/heap"
+	"context"
 	"errors"
+	"runtime"
 	"sort"
+	"sync"
 )
 
 // Pair is a pair of slice indexes to the sets in the input to all-pairs
@@ -19,96 +23,508 @@ type postingListEntry struct {
 	setSize       int
 }
 
+// allPairsParallelChunkSize is the number of probe sets a worker of
+// AllPairsParallel takes from the size-sorted order at a time.
+const allPairsParallelChunkSize = 64
+
+// allPairsJoin holds the similarity function and filters used by the
+// all-pairs algorithms.  The sets are indexed and probed by the probe sets,
+// which are the same sets for a self-join.
+type allPairsJoin struct {
+	sets                      [][]int
+	probeSets                 [][]int
+	selfJoin                  bool
+	threshold                 float64
+	overlapFunc               overlapFunction
+	overlapThresholdFunc      overlapThresholdFunction
+	overlapIndexThresholdFunc overlapThresholdFunction
+	positionFilterFunc        positionFilter
+	pairOverlapThresholdFunc  pairOverlapThresholdFunction
+	sizeBoundsFunc            sizeBoundsFunction
+	symmetric                 bool
+	suffixFilterDepth         int
+}
+
+func newAllPairsJoin(sets [][]int, similarityFunctionName string,
+	similarityThreshold float64) (*allPairsJoin, error) {
+	if len(sets) == 0 {
+		return nil, errors.New("input sets mut be a non-empty slice")
+	}
+	var overlapFunc overlapFunction
+	if f, exists := overlapFuncs[similarityFunctionName]; exists {
+		overlapFunc = f
+	} else {
+		return nil, errors.New("input similarityFunctionName does not exist")
+	}
+	if err := checkThreshold(similarityFunctionName, similarityThreshold); err != nil {
+		return nil, err
+	}
+	return &allPairsJoin{
+		sets:                      sets,
+		probeSets:                 sets,
+		selfJoin:                  true,
+		threshold:                 similarityThreshold,
+		overlapFunc:               overlapFunc,
+		overlapThresholdFunc:      overlapThresholdFuncs[similarityFunctionName],
+		overlapIndexThresholdFunc: overlapIndexThresholdFuncs[similarityFunctionName],
+		positionFilterFunc:        positionFilterFuncs[similarityFunctionName],
+		pairOverlapThresholdFunc:  pairOverlapThresholdFuncs[similarityFunctionName],
+		sizeBoundsFunc:            sizeBoundsFuncs[similarityFunctionName],
+		symmetric:                 symmetricSimilarityFuncs[similarityFunctionName],
+	}, nil
+}
+
+// sortedSetIndexes returns the indexes of the sets sorted by set length.
+// Sets with the same length are kept in input order.
+func sortedSetIndexes(sets [][]int) []int {
+	indexes := make([]int, len(sets))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return len(sets[indexes[i]]) < len(sets[indexes[j]])
+	})
+	return indexes
+}
+
+// index inserts the tokens in the prefix of the set x into the posting lists.
+func (j *allPairsJoin) index(x int, postingLists map[int][]postingListEntry) {
+	s := j.sets[x]
+	t := j.overlapIndexThresholdFunc(len(s), j.threshold)
+	prefixSize := len(s) - t + 1
+	prefix := s[:prefixSize]
+	for p, token := range prefix {
+		postingLists[token] = append(postingLists[token],
+			postingListEntry{x, p, len(s)})
+	}
+}
+
+// probe finds the pairs between the probe set x1 and the sets in the posting
+// lists, and appends them to pairs.  The posting lists must be sorted by set
+// size.  If rank is not nil, only the sets ranked before x1 are considered,
+// assuming each posting list is ordered by rank.
+// The accumulator is used as scratch space.
+func (j *allPairsJoin) probe(x1 int, postingLists map[int][]postingListEntry,
+	rank []int, acc *accumulator, pairs []Pair) []Pair {
+	s1 := j.probeSets[x1]
+	t := j.overlapThresholdFunc(len(s1), j.threshold)
+	prefixSize := len(s1) - t + 1
+	prefix := s1[:prefixSize]
+	// Find candidates using tokens in the prefix, and accumulate their
+	// overlaps.
+	acc.reset()
+	// Skip the sets smaller than the overlap threshold, and the sets outside
+	// the size bounds.
+	lower, upper := j.sizeBoundsFunc(len(s1), j.threshold)
+	lower = max(lower, t)
+	for p1, token := range prefix {
+		pl := postingList{entries: postingLists[token]}
+		start, end := pl.sizeRange(lower, upper)
+		for _, entry := range pl.entries[start:end] {
+			x2 := entry.setIndex
+			if rank != nil && rank[x2] >= rank[x1] {
+				break
+			}
+			if j.selfJoin && x2 == x1 {
+				continue
+			}
+			c, exists := acc.get(x2)
+			switch {
+			case c.pruned:
+				continue
+			case exists:
+				c.overlap++
+				c.p1, c.p2 = p1, entry.tokenPosition
+			case !j.positionFilterFunc(len(s1), entry.setSize, p1,
+				entry.tokenPosition, j.threshold):
+				// The first matching token is too far behind.
+				c.pruned = true
+			case j.suffixFilterDepth > 0 && !suffixFilterPasses(s1,
+				j.sets[x2], p1, entry.tokenPosition,
+				j.pairOverlapThresholdFunc(len(s1), entry.setSize,
+					j.threshold), j.suffixFilterDepth):
+				c.pruned = true
+			default:
+				c = candidate{overlap: 1, p1: p1, p2: entry.tokenPosition}
+			}
+			if !c.pruned && c.overlap+c.remaining(len(s1), entry.setSize) <
+				j.pairOverlapThresholdFunc(len(s1), entry.setSize,
+					j.threshold) {
+				c.pruned = true
+			}
+			acc.set(x2, c)
+		}
+	}
+	// Verify the candidates, continuing from their last matching tokens.
+	for _, x2 := range acc.order {
+		c, _ := acc.get(x2)
+		if c.pruned {
+			continue
+		}
+		s2 := j.sets[x2]
+		sim := j.overlapFunc(c.verify(s1, s2), len(s1), len(s2))
+		if sim < j.threshold {
+			continue
+		}
+		if !j.selfJoin || !j.symmetric {
+			// The pair is directed from the probe set.
+			pairs = append(pairs, Pair{x1, x2, sim})
+		} else if x1 > x2 {
+			pairs = append(pairs, Pair{x1, x2, sim})
+		} else {
+			pairs = append(pairs, Pair{x2, x1, sim})
+		}
+	}
+	return pairs
+}
+
+// sendPairs sends the pairs to the channel, and returns ctx.Err() if the
+// context is done before all pairs are sent.
+func sendPairs(ctx context.Context, out chan<- Pair, pairs []Pair) error {
+	for _, pair := range pairs {
+		select {
+		case out <- pair:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return ctx.Err()
+}
+
 // AllPairs finds all pairs of transformed sets with similarity greater than a
 // threshold.  This is an implementation of the All-Pair-Binary algorithm in the
 // paper "Scaling Up All Pairs Similarity Search" by Bayardo et al., with
 // position and length filter enhancement.
-// Currently supported similarity functions are "jaccard" and "cosine".
+// Currently supported similarity functions are "jaccard", "cosine", "dice",
+// "overlap", "intersection_size" and "containment".
 // This function returns a channel of Pairs which contains the indexes to
 // the input set slice.
+// For a symmetric similarity function such as "jaccard", each pair is found
+// once with X > Y.  For an asymmetric similarity function such as
+// "containment", each pair is directed: its Similarity is computed from X to
+// Y (e.g., the containment of X in Y), so X and Y may be found in both
+// directions, and a set is never paired with itself.
+// The channel must be drained, use AllPairsContext to stop early.
 func AllPairs(sets [][]int, similarityFunctionName string,
 	similarityThreshold float64) (<-chan Pair, error) {
-	if len(sets) == 0 {
-		return nil, errors.New("input sets mut be a non-empty slice")
-	}
-	if similarityThreshold < 0 || similarityThreshold > 1.0 {
-		return nil, errors.New("input similarityThreshold must be in the range [0, 1]")
-	}
-	var simFunc function
-	if f, exists := similarityFuncs[similarityFunctionName]; exists {
-		simFunc = f
-	} else {
-		return nil, errors.New("input similarityFunctionName does not exist")
+	pairs, _, err := AllPairsContext(context.Background(), sets,
+		similarityFunctionName, similarityThreshold)
+	return pairs, err
+}
+
+// AllPairsContext is the same as AllPairs, but stops when the context is
+// cancelled or its deadline is exceeded, so the consumer can stop reading
+// the pairs channel without leaking the search goroutine.
+// After the pairs channel is closed, the returned error channel receives
+// exactly one value: nil if all pairs were found, or the context's error
+// (context.Canceled or context.DeadlineExceeded) that stopped the search.
+func AllPairsContext(ctx context.Context, sets [][]int,
+	similarityFunctionName string, similarityThreshold float64) (<-chan Pair,
+	<-chan error, error) {
+	return AllPairsWithOptions(ctx, sets, similarityFunctionName,
+		similarityThreshold, AllPairsOptions{})
+}
+
+// AllPairsParallel is the same as AllPairs, but uses multiple workers to
+// find the pairs.  The size-sorted sets are indexed first into read-only
+// posting lists, and then the workers probe disjoint ranges of the sets
+// concurrently, so every pair is still found exactly once.
+// If workers is less than 1, runtime.NumCPU() workers are used.
+// The pairs are the same as the ones found by AllPairs, but may be sent to
+// the returned channel in a different order.
+func AllPairsParallel(sets [][]int, similarityFunctionName string,
+	similarityThreshold float64, workers int) (<-chan Pair, error) {
+	pairs, _, err := AllPairsParallelContext(context.Background(), sets,
+		similarityFunctionName, similarityThreshold, workers)
+	return pairs, err
+}
+
+// AllPairsParallelContext is the same as AllPairsParallel, but stops when the
+// context is done.  The returned error channel works the same way as the
+// one returned by AllPairsContext.
+func AllPairsParallelContext(ctx context.Context, sets [][]int,
+	similarityFunctionName string, similarityThreshold float64,
+	workers int) (<-chan Pair, <-chan error, error) {
+	if workers < 1 {
+		workers = -1
 	}
-	if !symmetricSimilarityFuncs[similarityFunctionName] {
-		return nil, errors.New("input similarityFunctionName is not symmetric")
+	return AllPairsWithOptions(ctx, sets, similarityFunctionName,
+		similarityThreshold, AllPairsOptions{Workers: workers})
+}
+
+// AllPairsOptions are the options of AllPairsWithOptions.
+type AllPairsOptions struct {
+	// Workers is the number of workers used to find the pairs as
+	// AllPairsParallel does.  If it is 0, the pairs are found by a single
+	// goroutine as AllPairs does, and if it is negative, runtime.NumCPU()
+	// workers are used.
+	Workers int
+	// SuffixFilterDepth enables the suffix filter of the PPJoin+ algorithm
+	// if positive, and is the maximum depth of its recursion.
+	// See SearchIndex.SetSuffixFilter.
+	SuffixFilterDepth int
+}
+
+// AllPairsWithOptions is the same as AllPairsContext, but takes options for
+// running the algorithm.
+func AllPairsWithOptions(ctx context.Context, sets [][]int,
+	similarityFunctionName string, similarityThreshold float64,
+	options AllPairsOptions) (<-chan Pair, <-chan error, error) {
+	j, err := newAllPairsJoin(sets, similarityFunctionName,
+		similarityThreshold)
+	if err != nil {
+		return nil, nil, err
 	}
-	overlapThresholdFunc := overlapThresholdFuncs[similarityFunctionName]
-	overlapIndexThresholdFunc := overlapIndexThresholdFuncs[similarityFunctionName]
-	positionFilterFunc := positionFilterFuncs[similarityFunctionName]
+	j.suffixFilterDepth = options.SuffixFilterDepth
 	pairs := make(chan Pair)
+	errc := make(chan error, 1)
 	go func() {
-		// Create a slice of set indexes.
-		indexes := make([]int, len(sets))
-		for i := range indexes {
-			indexes[i] = i
-		}
-		// Sort set indexes by set length.
-		sort.Slice(indexes, func(i, j int) bool {
-			return len(sets[i]) < len(sets[j])
-		})
+		defer close(errc)
 		defer close(pairs)
-		postingLists := make(map[int][]postingListEntry)
-		// Main loop of the All-Pairs algorithm.
-		for _, x1 := range indexes {
-			s1 := sets[x1]
-			t := overlapThresholdFunc(len(s1), similarityThreshold)
-			prefixSize := len(s1) - t + 1
-			prefix := s1[:prefixSize]
-			// Find candidates using tokens in the prefix.
-			candidates := make([]int, 0)
-			for p1, token := range prefix {
-				for _, entry := range postingLists[token] {
-					if positionFilterFunc(s1, sets[entry.setIndex], p1,
-						entry.tokenPosition, similarityThreshold) {
-						candidates = append(candidates, entry.setIndex)
+		switch {
+		case options.Workers == 0:
+			errc <- j.run(ctx, pairs)
+		case options.Workers < 0:
+			errc <- j.runParallel(ctx, pairs, runtime.NumCPU())
+		default:
+			errc <- j.runParallel(ctx, pairs, options.Workers)
+		}
+	}()
+	return pairs, errc, nil
+}
+
+// run is the main loop of the All-Pairs algorithm, which sends the pairs
+// found to the channel until the context is done.
+func (j *allPairsJoin) run(ctx context.Context, pairs chan<- Pair) error {
+	indexes := sortedSetIndexes(j.sets)
+	postingLists := make(map[int][]postingListEntry)
+	if !j.symmetric {
+		// An asymmetric similarity function requires probing with
+		// every set against all other sets, so index all sets first.
+		for _, x := range indexes {
+			j.index(x, postingLists)
+		}
+	}
+	acc := newAccumulator()
+	var found []Pair
+	for _, x1 := range indexes {
+		found = j.probe(x1, postingLists, nil, acc, found[:0])
+		if err := sendPairs(ctx, pairs, found); err != nil {
+			return err
+		}
+		if j.symmetric {
+			// Insert the tokens in the prefix into index.
+			j.index(x1, postingLists)
+		}
+	}
+	return nil
+}
+
+// runParallel runs the All-Pairs algorithm using multiple workers, which
+// send the pairs found to the channel until the context is done.
+func (j *allPairsJoin) runParallel(ctx context.Context, pairs chan<- Pair,
+	workers int) error {
+	// Index the prefixes of all sets in size order, so each posting
+	// list is ordered by rank.  Only a symmetric similarity function
+	// limits the probe of a set to the sets ranked before it.
+	indexes := sortedSetIndexes(j.sets)
+	var rank []int
+	if j.symmetric {
+		rank = make([]int, len(j.sets))
+	}
+	postingLists := make(map[int][]postingListEntry)
+	for r, x := range indexes {
+		if r%allPairsParallelChunkSize == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if rank != nil {
+			rank[x] = r
+		}
+		j.index(x, postingLists)
+	}
+	// Hand out ranges of probe sets to the workers.
+	ranges := make(chan int)
+	var dispatchErr error
+	go func() {
+		defer close(ranges)
+		for start := 0; start < len(indexes); start += allPairsParallelChunkSize {
+			select {
+			case ranges <- start:
+			case <-ctx.Done():
+				dispatchErr = ctx.Err()
+				return
+			}
+		}
+	}()
+	// Keep the first error from the workers.
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			acc := newAccumulator()
+			var found []Pair
+			for start := range ranges {
+				end := min(start+allPairsParallelChunkSize, len(indexes))
+				for _, x1 := range indexes[start:end] {
+					found = j.probe(x1, postingLists, rank, acc,
+						found[:0])
+					if err := sendPairs(ctx, pairs, found); err != nil {
+						once.Do(func() { firstErr = err })
+						return
 					}
 				}
 			}
-			// Sort and iterate through candidate indexes to verify
-			// pairs.
-			// TODO: optimize using partial overlaps.
-			sort.Ints(candidates)
-			prevCandidate := -1
-			for _, x2 := range candidates {
-				// Skip seen candidate.
-				if x2 == prevCandidate {
-					continue
-				}
-				prevCandidate = x2
-				// Compute the exact similarity of this candidate
-				sim := simFunc(s1, sets[x2])
-				if sim < similarityThreshold {
-					continue
-				}
-				if x1 > x2 {
-					pairs <- Pair{x1, x2, sim}
-				} else {
-					pairs <- Pair{x2, x1, sim}
+		}()
+	}
+	wg.Wait()
+	if firstErr == nil {
+		// The ranges may have stopped before all probe sets were
+		// handed out.
+		firstErr = dispatchErr
+	}
+	return firstErr
+}
+
+// AllPairsRS finds all pairs of transformed sets between the left and the
+// right sets with similarity greater than a threshold.  The two collections
+// are never joined with themselves, so each Pair has X as the index to the
+// left sets and Y as the index to the right sets.
+// For a symmetric similarity function, the smaller collection is indexed
+// and the other one is used for probing.  For an asymmetric similarity
+// function such as "containment", the right sets are always indexed, and
+// the Similarity is computed from the left set to the right set.
+// The channel must be drained, use AllPairsRSContext to stop early.
+func AllPairsRS(left, right [][]int, similarityFunctionName string,
+	similarityThreshold float64) (<-chan Pair, error) {
+	pairs, _, err := AllPairsRSContext(context.Background(), left, right,
+		similarityFunctionName, similarityThreshold)
+	return pairs, err
+}
+
+// AllPairsRSContext is the same as AllPairsRS, but stops when the context is
+// done.  The returned error channel works the same way as the one returned
+// by AllPairsContext.
+func AllPairsRSContext(ctx context.Context, left, right [][]int,
+	similarityFunctionName string, similarityThreshold float64) (<-chan Pair,
+	<-chan error, error) {
+	if len(left) == 0 || len(right) == 0 {
+		return nil, nil, errors.New("input left and right sets must be non-empty slices")
+	}
+	j, err := newAllPairsJoin(right, similarityFunctionName,
+		similarityThreshold)
+	if err != nil {
+		return nil, nil, err
+	}
+	j.probeSets = left
+	j.selfJoin = false
+	swapped := j.symmetric && len(left) < len(right)
+	if swapped {
+		j.sets, j.probeSets = left, right
+	}
+	pairs := make(chan Pair)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(pairs)
+		// Index the sets in size order so the posting lists are sorted by
+		// set size.
+		postingLists := make(map[int][]postingListEntry)
+		for _, x := range sortedSetIndexes(j.sets) {
+			j.index(x, postingLists)
+		}
+		acc := newAccumulator()
+		var found []Pair
+		for x1 := range j.probeSets {
+			found = j.probe(x1, postingLists, nil, acc, found[:0])
+			if swapped {
+				for i := range found {
+					found[i].X, found[i].Y = found[i].Y, found[i].X
 				}
 			}
-			// Insert the tokens in the prefix into index.
-			t = overlapIndexThresholdFunc(len(s1), similarityThreshold)
-			prefixSize = len(s1) - t + 1
-			prefix = s1[:prefixSize]
-			for j, token := range prefix {
-				if _, exists := postingLists[token]; !exists {
-					postingLists[token] = make([]postingListEntry, 0)
-				}
-				postingLists[token] = append(postingLists[token],
-					postingListEntry{x1, j, len(sets[x1])})
+			if err := sendPairs(ctx, pairs, found); err != nil {
+				errc <- err
+				return
 			}
 		}
+		errc <- nil
 	}()
-	return pairs, nil
+	return pairs, errc, nil
+}
+
+// pairHeap is a min-heap of pairs ordered by similarity.
+type pairHeap []Pair
+
+func (h pairHeap) Len() int           { return len(h) }
+func (h pairHeap) Less(i, j int) bool { return h[i].Similarity < h[j].Similarity }
+func (h pairHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *pairHeap) Push(x interface{}) {
+	*h = append(*h, x.(Pair))
+}
+
+func (h *pairHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// AllPairsTopK finds the k pairs of transformed sets with the highest
+// similarity, without a similarity threshold.  It runs the same algorithm as
+// AllPairs, starting with a similarity threshold of 0 and raising it to the
+// similarity of the k-th best pair found so far, so the prefixes and the
+// position filter become tighter as better pairs are found.
+// Only the pairs sharing at least one token are considered.
+// The pairs are the same as the ones found by AllPairs, and are returned in
+// descending order of similarity.
+func AllPairsTopK(sets [][]int, similarityFunctionName string,
+	k int) ([]Pair, error) {
+	if k < 1 {
+		return nil, errors.New("input k must be positive")
+	}
+	j, err := newAllPairsJoin(sets, similarityFunctionName, 0)
+	if err != nil {
+		return nil, err
+	}
+	indexes := sortedSetIndexes(sets)
+	postingLists := make(map[int][]postingListEntry)
+	if !j.symmetric {
+		for _, x := range indexes {
+			j.index(x, postingLists)
+		}
+	}
+	results := make(pairHeap, 0, k)
+	acc := newAccumulator()
+	var found []Pair
+	for _, x1 := range indexes {
+		found = j.probe(x1, postingLists, nil, acc, found[:0])
+		for _, pair := range found {
+			if len(results) < k {
+				heap.Push(&results, pair)
+			} else if pair.Similarity > results[0].Similarity {
+				results[0] = pair
+				heap.Fix(&results, 0)
+			}
+		}
+		// Raise the threshold to the similarity of the k-th best pair.
+		if len(results) == k {
+			j.threshold = results[0].Similarity
+		}
+		if j.symmetric {
+			j.index(x1, postingLists)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Similarity != results[j].Similarity {
+			return results[i].Similarity > results[j].Similarity
+		}
+		if results[i].X != results[j].X {
+			return results[i].X < results[j].X
+		}
+		return results[i].Y < results[j].Y
+	})
+	return results, nil
 }
@@ -1,6 +1,11 @@
 package SetSimilaritySearch
 
-import "testing"
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+)
 
 func pairExists(p Pair, pairs []Pair) bool {
 	for i := range pairs {
@@ -39,3 +44,273 @@ func TestAllPairJaccard(t *testing.T) {
 		t.Errorf("Expecting %d pairs but found %d", len(correctPairs), count)
 	}
 }
+
+func randomSets(n, maxSize, numTokens int, seed int64) [][]int {
+	r := rand.New(rand.NewSource(seed))
+	sets := make([][]int, n)
+	for i := range sets {
+		size := 1 + r.Intn(maxSize)
+		set := make([]int, 0, size)
+		for _, token := range r.Perm(numTokens)[:size] {
+			set = append(set, token)
+		}
+		sort.Ints(set)
+		sets[i] = set
+	}
+	return sets
+}
+
+func collectPairs(pairs <-chan Pair) map[Pair]bool {
+	found := make(map[Pair]bool)
+	for p := range pairs {
+		found[p] = true
+	}
+	return found
+}
+
+func TestAllPairsParallel(t *testing.T) {
+	sets := randomSets(500, 20, 50, 42)
+	for _, function := range []string{"jaccard", "cosine"} {
+		pairs, err := AllPairs(sets, function, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		correctPairs := collectPairs(pairs)
+		if len(correctPairs) == 0 {
+			t.Fatalf("Expecting some %s pairs in the test input", function)
+		}
+		for _, workers := range []int{0, 1, 3, 8} {
+			pairs, err := AllPairsParallel(sets, function, 0.5, workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count := 0
+			for p := range pairs {
+				if !correctPairs[p] {
+					t.Errorf("The pair %v is not correct", p)
+				}
+				count++
+			}
+			if count != len(correctPairs) {
+				t.Errorf("Expecting %d %s pairs with %d workers but found %d",
+					len(correctPairs), function, workers, count)
+			}
+		}
+	}
+}
+
+func TestAllPairsContext(t *testing.T) {
+	sets := randomSets(500, 20, 50, 42)
+	// Run to completion.
+	pairs, errc, err := AllPairsContext(context.Background(), sets,
+		"jaccard", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collectPairs(pairs)
+	if err := <-errc; err != nil {
+		t.Errorf("Expecting no error after completion, got %v", err)
+	}
+	// Stop reading after the first pair.
+	for _, workers := range []int{-1, 1, 4} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var errc <-chan error
+		if workers < 0 {
+			pairs, errc, err = AllPairsContext(ctx, sets, "jaccard", 0.5)
+		} else {
+			pairs, errc, err = AllPairsParallelContext(ctx, sets, "jaccard",
+				0.5, workers)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-pairs
+		cancel()
+		for range pairs {
+		}
+		if err := <-errc; err != context.Canceled {
+			t.Errorf("Expecting %v, got %v", context.Canceled, err)
+		}
+	}
+}
+
+func TestAllPairsContainment(t *testing.T) {
+	sets := randomSets(300, 20, 40, 8)
+	correctPairs := make(map[Pair]bool)
+	for x := range sets {
+		for y := range sets {
+			if x == y {
+				continue
+			}
+			if sim := containment(sets[x], sets[y]); sim >= 0.6 {
+				correctPairs[Pair{x, y, sim}] = true
+			}
+		}
+	}
+	if len(correctPairs) == 0 {
+		t.Fatal("Expecting some containment pairs in the test input")
+	}
+	for _, workers := range []int{-1, 1, 4} {
+		var pairs <-chan Pair
+		var err error
+		if workers < 0 {
+			pairs, err = AllPairs(sets, "containment", 0.6)
+		} else {
+			pairs, err = AllPairsParallel(sets, "containment", 0.6, workers)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for p := range pairs {
+			if !correctPairs[p] {
+				t.Errorf("The pair %v is not correct", p)
+			}
+			count++
+		}
+		if count != len(correctPairs) {
+			t.Errorf("Expecting %d pairs but found %d", len(correctPairs),
+				count)
+		}
+	}
+}
+
+func TestAllPairsRS(t *testing.T) {
+	left := randomSets(100, 20, 40, 9)
+	right := randomSets(250, 20, 40, 10)
+	for _, function := range []string{"jaccard", "cosine", "containment"} {
+		// Test both the left and the right being the smaller side.
+		for _, swap := range []bool{false, true} {
+			l, r := left, right
+			if swap {
+				l, r = right, left
+			}
+			correctPairs := make(map[Pair]bool)
+			for x := range l {
+				for y := range r {
+					sim := similarityFuncs[function](l[x], r[y])
+					if sim >= 0.5 {
+						correctPairs[Pair{x, y, sim}] = true
+					}
+				}
+			}
+			if len(correctPairs) == 0 {
+				t.Fatalf("Expecting some %s pairs in the test input", function)
+			}
+			pairs, err := AllPairsRS(l, r, function, 0.5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count := 0
+			for p := range pairs {
+				if !correctPairs[p] {
+					t.Errorf("The pair %v is not correct", p)
+				}
+				count++
+			}
+			if count != len(correctPairs) {
+				t.Errorf("Expecting %d %s pairs but found %d",
+					len(correctPairs), function, count)
+			}
+		}
+	}
+}
+
+func TestAllPairsTopK(t *testing.T) {
+	sets := randomSets(300, 20, 50, 11)
+	for _, function := range []string{"jaccard", "cosine", "containment"} {
+		// Use a threshold low enough to find more than k pairs.
+		pairs, err := AllPairs(sets, function, 0.3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		correctPairs := collectPairs(pairs)
+		sims := make([]float64, 0, len(correctPairs))
+		for p := range correctPairs {
+			sims = append(sims, p.Similarity)
+		}
+		sort.Sort(sort.Reverse(sort.Float64Slice(sims)))
+		for _, k := range []int{1, 10, 100} {
+			topPairs, err := AllPairsTopK(sets, function, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(topPairs) != k {
+				t.Fatalf("Expecting %d pairs got %d", k, len(topPairs))
+			}
+			for i, p := range topPairs {
+				if !correctPairs[p] {
+					t.Errorf("The pair %v is not correct", p)
+				}
+				if p.Similarity != sims[i] {
+					t.Errorf("Expecting similarity %f at rank %d got %v",
+						sims[i], i, p)
+				}
+			}
+		}
+	}
+	if _, err := AllPairsTopK(sets, "jaccard", 0); err == nil {
+		t.Error("Expecting error for non-positive k")
+	}
+}
+
+func TestAllPairsDice(t *testing.T) {
+	sets := randomSets(300, 20, 50, 15)
+	for _, threshold := range []float64{0.3, 0.5, 0.8} {
+		correctPairs := make(map[Pair]bool)
+		for x1 := range sets {
+			for x2 := 0; x2 < x1; x2++ {
+				if sim := dice(sets[x1], sets[x2]); sim >= threshold {
+					correctPairs[Pair{x1, x2, sim}] = true
+				}
+			}
+		}
+		if len(correctPairs) == 0 {
+			t.Fatalf("Expecting some pairs with threshold %v in the test input",
+				threshold)
+		}
+		pairs, err := AllPairs(sets, "dice", threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := collectPairs(pairs)
+		for p := range found {
+			if !correctPairs[p] {
+				t.Errorf("The pair %v is not correct", p)
+			}
+		}
+		if len(found) != len(correctPairs) {
+			t.Errorf("Expecting %d pairs with threshold %v but found %d",
+				len(correctPairs), threshold, len(found))
+		}
+	}
+}
+
+func TestAllPairsIntersectionSize(t *testing.T) {
+	sets := randomSets(300, 20, 50, 20)
+	for _, threshold := range []float64{3, 6} {
+		correctPairs := make(map[Pair]bool)
+		for x1 := range sets {
+			for x2 := 0; x2 < x1; x2++ {
+				overlap := intersectionSize(sets[x1], sets[x2])
+				if sim := float64(overlap); sim >= threshold {
+					correctPairs[Pair{x1, x2, sim}] = true
+				}
+			}
+		}
+		pairs, err := AllPairs(sets, "intersection_size", threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := collectPairs(pairs)
+		for p := range found {
+			if !correctPairs[p] {
+				t.Errorf("The pair %v is not correct", p)
+			}
+		}
+		if len(found) != len(correctPairs) {
+			t.Errorf("Expecting %d pairs with threshold %v but found %d",
+				len(correctPairs), threshold, len(found))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package SetSimilaritySearch
+
+// candidate is a set that shares prefix tokens with a probing set, with the
+// overlap accumulated from the posting lists so far.
+type candidate struct {
+	// overlap is the number of matching tokens found in the prefixes.
+	overlap int
+	// p1 and p2 are the positions of the last matching token in the probing
+	// set and in the candidate.
+	p1, p2 int
+	// pruned is set when the candidate cannot meet the threshold.
+	pruned bool
+}
+
+// remaining returns the maximum number of matching tokens after the last
+// matching token, given the sizes of the probing set and the candidate.
+func (c candidate) remaining(l1, l2 int) int {
+	return min(l1-c.p1-1, l2-c.p2-1)
+}
+
+// verify returns the overlap of the probing set and the candidate set.
+// Every matching token up to the last one found in the prefixes has been
+// accumulated, so only the tokens after it need to be intersected.
+func (c candidate) verify(s1, s2 []int) int {
+	return c.overlap + intersectionSize(s1[c.p1+1:], s2[c.p2+1:])
+}
+
+// accumulator accumulates the overlaps of the candidates found by probing
+// the posting lists with the prefix of a set, as in the All-Pairs and
+// PPJoin algorithms, so the candidates do not need to be sorted and
+// deduplicated, and each one is verified once from where its prefix overlap
+// ends.
+type accumulator struct {
+	candidates map[int]candidate
+	// order is the candidate indexes in the order they are found.
+	order []int
+}
+
+func newAccumulator() *accumulator {
+	return &accumulator{candidates: make(map[int]candidate)}
+}
+
+// reset removes all candidates so the accumulator can be reused.
+func (a *accumulator) reset() {
+	for _, x := range a.order {
+		delete(a.candidates, x)
+	}
+	a.order = a.order[:0]
+}
+
+// get returns the candidate x, and whether it has been found before.
+func (a *accumulator) get(x int) (candidate, bool) {
+	c, exists := a.candidates[x]
+	return c, exists
+}
+
+// set records the candidate x.
+func (a *accumulator) set(x int, c candidate) {
+	if _, exists := a.candidates[x]; !exists {
+		a.order = append(a.order, x)
+	}
+	a.candidates[x] = c
+}
@@ -0,0 +1,34 @@
+package SetSimilaritySearch
+
+import "testing"
+
+func TestCandidateVerify(t *testing.T) {
+	s1 := []int{1, 2, 3, 5, 8, 9}
+	s2 := []int{2, 3, 4, 5, 9}
+	// Tokens 2 and 3 are matched in the prefixes.
+	c := candidate{overlap: 2, p1: 2, p2: 1}
+	if overlap := c.verify(s1, s2); overlap != intersectionSize(s1, s2) {
+		t.Errorf("Expected overlap %d, got %d", intersectionSize(s1, s2),
+			overlap)
+	}
+	if remaining := c.remaining(len(s1), len(s2)); remaining != 3 {
+		t.Errorf("Expected 3 remaining tokens, got %d", remaining)
+	}
+}
+
+func TestAccumulator(t *testing.T) {
+	acc := newAccumulator()
+	acc.set(3, candidate{overlap: 1})
+	acc.set(1, candidate{overlap: 1})
+	acc.set(3, candidate{overlap: 2})
+	if len(acc.order) != 2 || acc.order[0] != 3 || acc.order[1] != 1 {
+		t.Errorf("Expected candidates in order [3 1], got %v", acc.order)
+	}
+	if c, exists := acc.get(3); !exists || c.overlap != 2 {
+		t.Errorf("Expected candidate 3 with overlap 2, got %v", c)
+	}
+	acc.reset()
+	if _, exists := acc.get(3); exists || len(acc.order) != 0 {
+		t.Error("Expected no candidates after reset")
+	}
+}
@@ -0,0 +1,63 @@
+package SetSimilaritySearch
+
+import "sort"
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// suffixFilter returns a lower bound of the Hamming distance between two
+// transformed sets, i.e., the number of tokens in only one of them.
+// It recursively partitions the sets using the middle token of s2, and
+// stops once the lower bound exceeds maxDistance or the recursion reaches
+// maxDepth.  This is the suffix filter in the paper "Efficient Similarity
+// Joins for Near Duplicate Detection" by Xiao et al..
+func suffixFilter(s1, s2 []int, maxDistance, depth, maxDepth int) int {
+	distance := abs(len(s1) - len(s2))
+	if depth >= maxDepth || len(s1) == 0 || len(s2) == 0 ||
+		distance > maxDistance {
+		return distance
+	}
+	// Partition both sets using the middle token of s2.
+	mid := len(s2) / 2
+	token := s2[mid]
+	l2, r2 := s2[:mid], s2[mid+1:]
+	p := sort.SearchInts(s1, token)
+	l1, r1 := s1[:p], s1[p:]
+	diff := 1
+	if p < len(s1) && s1[p] == token {
+		r1 = s1[p+1:]
+		diff = 0
+	}
+	rightDistance := abs(len(r1) - len(r2))
+	distance = abs(len(l1)-len(l2)) + rightDistance + diff
+	if distance > maxDistance {
+		return distance
+	}
+	leftDistance := suffixFilter(l1, l2, maxDistance-rightDistance-diff,
+		depth+1, maxDepth)
+	distance = leftDistance + rightDistance + diff
+	if distance > maxDistance {
+		return distance
+	}
+	rightDistance = suffixFilter(r1, r2, maxDistance-leftDistance-diff,
+		depth+1, maxDepth)
+	return leftDistance + rightDistance + diff
+}
+
+// suffixFilterPasses returns whether two transformed sets can have at least
+// the given overlap, given that their first matching token is at position p1
+// in s1 and p2 in s2.  All tokens before the first matching token are not
+// in the overlap, so the suffixes after it can differ by at most the
+// remaining Hamming distance.
+func suffixFilterPasses(s1, s2 []int, p1, p2, overlap, maxDepth int) bool {
+	maxDistance := len(s1) + len(s2) - 2*overlap - (p1 + p2)
+	if maxDistance < 0 {
+		return false
+	}
+	return suffixFilter(s1[p1+1:], s2[p2+1:], maxDistance, 0,
+		maxDepth) <= maxDistance
+}
@@ -0,0 +1,75 @@
+package SetSimilaritySearch
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSuffixFilter(t *testing.T) {
+	sets := randomSets(100, 30, 60, 12)
+	for i := range sets {
+		for j := range sets {
+			s1, s2 := sets[i], sets[j]
+			distance := len(s1) + len(s2) - 2*intersectionSize(s1, s2)
+			for _, maxDepth := range []int{1, 2, 4} {
+				lowerBound := suffixFilter(s1, s2, distance, 0, maxDepth)
+				if lowerBound > distance {
+					t.Fatalf("Lower bound %d is more than the Hamming distance %d of %v and %v",
+						lowerBound, distance, s1, s2)
+				}
+			}
+		}
+	}
+}
+
+func TestSuffixFilterAllPairs(t *testing.T) {
+	sets := randomSets(500, 30, 60, 13)
+	for _, function := range []string{"jaccard", "cosine", "containment"} {
+		pairs, err := AllPairs(sets, function, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		correctPairs := collectPairs(pairs)
+		for _, workers := range []int{0, 4} {
+			pairs, _, err := AllPairsWithOptions(context.Background(), sets,
+				function, 0.5, AllPairsOptions{
+					Workers:           workers,
+					SuffixFilterDepth: 2,
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := collectPairs(pairs)
+			for p := range found {
+				if !correctPairs[p] {
+					t.Errorf("The pair %v is not correct", p)
+				}
+			}
+			if len(found) != len(correctPairs) {
+				t.Errorf("Expecting %d %s pairs but found %d",
+					len(correctPairs), function, len(found))
+			}
+		}
+	}
+}
+
+func TestSuffixFilterSearchIndex(t *testing.T) {
+	sets := randomSets(300, 30, 60, 14)
+	for _, function := range []string{"jaccard", "cosine", "containment"} {
+		searchIndex, err := NewSearchIndex(sets, function, 0.4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		searchIndex.SetSuffixFilter(3)
+		for _, query := range sets[:100] {
+			correctResults := bruteForceQuery(sets, nil, query,
+				similarityFuncs[function], 0.4)
+			checkResults(t, searchIndex.Query(query), correctResults)
+			for _, r := range searchIndex.QueryTopK(query, 5) {
+				if !resultExists(r, correctResults) {
+					t.Errorf("The result %v is not correct", r)
+				}
+			}
+		}
+	}
+}
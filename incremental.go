@@ -0,0 +1,124 @@
+package SetSimilaritySearch
+
+// IncrementalDictionary is a dictionary that absorbs new collections of raw
+// sets without changing the integer tokens of the raw tokens it already has,
+// so the transformed sets in existing search indexes stay valid.
+// New raw tokens are appended after all existing raw tokens, which keeps the
+// global order consistent for prefix filtering, but places them later than
+// their frequencies would.  As the frequencies drift, Rerank reports how far
+// the order is from the current frequency order, and gives a new dictionary
+// to transform the sets again and rebuild the indexes with.
+// An IncrementalDictionary is not safe for concurrent use.
+type IncrementalDictionary struct {
+	dict   Dictionary
+	counts map[string]int
+	// numInitialTokens is the number of raw tokens ordered by frequency
+	// when the dictionary was created.
+	numInitialTokens int
+}
+
+// NewIncrementalDictionary transforms string sets to integer sets according
+// to global frequency order as FrequencyOrderTransform does, and returns the
+// transformed sets and an incremental dictionary.
+func NewIncrementalDictionary(rawSets [][]string) (sets [][]int,
+	dict *IncrementalDictionary) {
+	dict = &IncrementalDictionary{
+		dict:   make(Dictionary),
+		counts: make(map[string]int),
+	}
+	sets = dict.Add(rawSets)
+	dict.numInitialTokens = len(dict.dict)
+	return sets, dict
+}
+
+// Add adds a collection of string sets to the dictionary, and returns the
+// transformed sets.  The raw tokens not in the dictionary are given new
+// integer tokens after all existing ones, in the frequency order within the
+// collection.
+func (dict *IncrementalDictionary) Add(rawSets [][]string) [][]int {
+	newCounts := make(map[string]int)
+	countFrequencies(rawSets, newCounts)
+	newRawTokens := make(map[string]int)
+	for rawToken, freq := range newCounts {
+		dict.counts[rawToken] += freq
+		if _, exists := dict.dict[rawToken]; !exists {
+			newRawTokens[rawToken] = freq
+		}
+	}
+	for _, rawToken := range frequencyOrder(newRawTokens, lessString) {
+		dict.dict[rawToken] = len(dict.dict)
+	}
+	sets := make([][]int, len(rawSets))
+	for i, rawSet := range rawSets {
+		sets[i] = dict.dict.Transform(rawSet)
+	}
+	return sets
+}
+
+// Transform takes a set of raw tokens and returns a set of integer tokens,
+// dropping the raw tokens not in the dictionary as Dictionary.Transform
+// does.
+func (dict *IncrementalDictionary) Transform(rawSet []string) []int {
+	return dict.dict.Transform(rawSet)
+}
+
+// Dictionary returns a copy of the current dictionary.
+func (dict *IncrementalDictionary) Dictionary() Dictionary {
+	d := make(Dictionary, len(dict.dict))
+	for rawToken, token := range dict.dict {
+		d[rawToken] = token
+	}
+	return d
+}
+
+// Frequency returns the number of sets added to the dictionary containing
+// the raw token.
+func (dict *IncrementalDictionary) Frequency(rawToken string) int {
+	return dict.counts[rawToken]
+}
+
+// RerankReport compares the integer tokens of an IncrementalDictionary with
+// the ranks of the raw tokens in the current frequency order.
+type RerankReport struct {
+	// NumTokens is the number of raw tokens in the dictionary.
+	NumTokens int
+	// NumAppended is the number of raw tokens appended by Add after the
+	// dictionary was created.
+	NumAppended int
+	// NumMoved is the number of raw tokens whose integer tokens differ from
+	// their ranks.
+	NumMoved int
+	// MeanDisplacement and MaxDisplacement are the mean and maximum absolute
+	// differences between the integer tokens and the ranks.
+	MeanDisplacement float64
+	MaxDisplacement  int
+	// Dictionary maps the raw tokens to their ranks, to transform the sets
+	// again in the current frequency order.
+	Dictionary Dictionary
+}
+
+// Rerank returns a report of how far the integer tokens of the dictionary
+// are from the current frequency order of the raw tokens.  It does not
+// change the dictionary.
+func (dict *IncrementalDictionary) Rerank() RerankReport {
+	report := RerankReport{
+		NumTokens:   len(dict.dict),
+		NumAppended: len(dict.dict) - dict.numInitialTokens,
+		Dictionary:  make(Dictionary, len(dict.dict)),
+	}
+	var totalDisplacement int
+	for rank, rawToken := range frequencyOrder(dict.counts, lessString) {
+		report.Dictionary[rawToken] = rank
+		displacement := abs(dict.dict[rawToken] - rank)
+		if displacement > 0 {
+			report.NumMoved++
+		}
+		totalDisplacement += displacement
+		report.MaxDisplacement = max(report.MaxDisplacement, displacement)
+	}
+	if report.NumTokens > 0 {
+		report.MeanDisplacement = float64(totalDisplacement) /
+			float64(report.NumTokens)
+	}
+	return report
+}
@@ -0,0 +1,64 @@
+package SetSimilaritySearch
+
+import "testing"
+
+func TestIncrementalDictionary(t *testing.T) {
+	rawSets := [][]string{
+		[]string{"a", "b", "c"},
+		[]string{"a", "b"},
+		[]string{"a"},
+	}
+	sets, dict := NewIncrementalDictionary(rawSets)
+	correctSets, correctDict := FrequencyOrderTransform(rawSets)
+	for rawToken, token := range correctDict {
+		if dict.Dictionary()[rawToken] != token {
+			t.Errorf("Expect %v's token is %v, got %v", rawToken, token,
+				dict.Dictionary()[rawToken])
+		}
+	}
+	if report := dict.Rerank(); report.NumMoved != 0 || report.NumAppended != 0 {
+		t.Errorf("Expect no drift for a new dictionary, got %+v", report)
+	}
+	// Add a collection with new tokens and changed frequencies.
+	newRawSets := [][]string{
+		[]string{"c", "d", "e"},
+		[]string{"c", "d"},
+		[]string{"c", "e", "a"},
+	}
+	newSets := dict.Add(newRawSets)
+	for rawToken, token := range correctDict {
+		if dict.Dictionary()[rawToken] != token {
+			t.Errorf("Expect %v's token to stay %v, got %v", rawToken, token,
+				dict.Dictionary()[rawToken])
+		}
+	}
+	if dict.Dictionary()["d"] != 3 || dict.Dictionary()["e"] != 4 {
+		t.Errorf("Expect new tokens appended, got %v", dict.Dictionary())
+	}
+	if dict.Frequency("c") != 4 {
+		t.Errorf("Expect frequency 4, got %d", dict.Frequency("c"))
+	}
+	// An index of the existing sets is still correct for the new sets.
+	searchIndex, err := NewSearchIndex(correctSets, "jaccard", 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range newSets {
+		searchIndex.Add(s)
+	}
+	allSets := append(append([][]int{}, sets...), newSets...)
+	for _, query := range allSets {
+		checkResults(t, searchIndex.Query(query),
+			bruteForceQuery(allSets, nil, query, jaccard, 0.3))
+	}
+	report := dict.Rerank()
+	if report.NumTokens != 5 || report.NumAppended != 2 {
+		t.Errorf("Expect 5 tokens with 2 appended, got %+v", report)
+	}
+	if report.NumMoved == 0 || report.MaxDisplacement == 0 {
+		t.Errorf("Expect the most frequent token c to move, got %+v", report)
+	}
+	if report.Dictionary["c"] != 4 {
+		t.Errorf("Expect c to be ranked last, got %v", report.Dictionary)
+	}
+}
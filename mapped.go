@@ -0,0 +1,403 @@
+package SetSimilaritySearch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// mappedIndexMagic identifies the flat file format of a search index.
+var mappedIndexMagic = [4]byte{'S', 'S', 'S', 'M'}
+
+// mappedIndexFormatVersion is the version of the flat file format written by
+// SearchIndex.WriteMappable.  It must be incremented when the format
+// changes.
+const mappedIndexFormatVersion = 1
+
+// mappedEntrySize is the number of bytes of a posting list entry in the flat
+// file format.
+const mappedEntrySize = 12
+
+// postingList is a posting list that is either in memory or in the flat file
+// format.
+type postingList struct {
+	entries []postingListEntry
+	data    []byte
+}
+
+func (pl postingList) len() int {
+	if pl.data != nil {
+		return len(pl.data) / mappedEntrySize
+	}
+	return len(pl.entries)
+}
+
+func (pl postingList) at(i int) postingListEntry {
+	if pl.data != nil {
+		b := pl.data[i*mappedEntrySize:]
+		return postingListEntry{
+			setIndex:      int(binary.LittleEndian.Uint32(b)),
+			tokenPosition: int(binary.LittleEndian.Uint32(b[4:])),
+			setSize:       int(binary.LittleEndian.Uint32(b[8:])),
+		}
+	}
+	return pl.entries[i]
+}
+
+// sizeRange returns the range [start, end) of the entries whose set sizes are
+// between lower and upper inclusive, as the entries are sorted by set size.
+func (pl postingList) sizeRange(lower, upper int) (int, int) {
+	start := sort.Search(pl.len(), func(i int) bool {
+		return pl.at(i).setSize >= lower
+	})
+	end := start + sort.Search(pl.len()-start, func(i int) bool {
+		return pl.at(start+i).setSize > upper
+	})
+	return start, end
+}
+
+// mappedIndex is the sets and posting lists of a search index in the flat
+// file format:
+//
+//	magic          [4]byte "SSSM"
+//	version        uint32
+//	threshold      float64
+//	function name  uint32 length followed by the bytes
+//	numSets        uint64
+//	numSetTokens   uint64, the total size of all sets
+//	numTokens      uint64, the number of posting lists
+//	numEntries     uint64, the total size of all posting lists
+//	removed        [numSets]byte, 1 for a removed set
+//	setOffsets     [numSets+1]uint64, offsets into setTokens
+//	setTokens      [numSetTokens]uint32
+//	tokens         [numTokens]uint32, sorted
+//	postingOffsets [numTokens+1]uint64, offsets into entries
+//	entries        [numEntries]{setIndex, tokenPosition, setSize uint32}
+//
+// All integers are little-endian.  The sets and posting lists are compressed
+// sparse row arrays, so they are read directly from the mapped file without
+// being loaded into memory.
+type mappedIndex struct {
+	numSets        int
+	numTokens      int
+	removed        []byte
+	setOffsets     []byte
+	setTokens      []byte
+	tokens         []byte
+	postingOffsets []byte
+	entries        []byte
+	unmap          func() error
+}
+
+func (m *mappedIndex) isRemoved(x int) bool {
+	return m.removed[x] != 0
+}
+
+func (m *mappedIndex) setOffset(x int) int {
+	return int(binary.LittleEndian.Uint64(m.setOffsets[x*8:]))
+}
+
+func (m *mappedIndex) set(x int, buf []int) []int {
+	start, end := m.setOffset(x), m.setOffset(x+1)
+	buf = buf[:0]
+	for i := start; i < end; i++ {
+		buf = append(buf, int(binary.LittleEndian.Uint32(m.setTokens[i*4:])))
+	}
+	return buf
+}
+
+func (m *mappedIndex) token(i int) int {
+	return int(binary.LittleEndian.Uint32(m.tokens[i*4:]))
+}
+
+// postingListAt returns the i-th posting list in token order.
+func (m *mappedIndex) postingListAt(i int) postingList {
+	start := int(binary.LittleEndian.Uint64(m.postingOffsets[i*8:]))
+	end := int(binary.LittleEndian.Uint64(m.postingOffsets[(i+1)*8:]))
+	return postingList{data: m.entries[start*mappedEntrySize : end*mappedEntrySize]}
+}
+
+func (m *mappedIndex) postingList(token int) postingList {
+	// Binary search the sorted tokens.
+	i := sort.Search(m.numTokens, func(i int) bool {
+		return m.token(i) >= token
+	})
+	if i == m.numTokens || m.token(i) != token {
+		return postingList{}
+	}
+	return m.postingListAt(i)
+}
+
+func (m *mappedIndex) forEachPostingList(f func(token int, pl postingList)) {
+	for i := 0; i < m.numTokens; i++ {
+		f(m.token(i), m.postingListAt(i))
+	}
+}
+
+// readMappedIndex parses the flat file format in data, and returns the
+// similarity function name, the threshold and the sets and posting lists.
+func readMappedIndex(data []byte) (string, float64, *mappedIndex, error) {
+	errFormat := errors.New("input is not a valid mapped search index")
+	// Read the header.
+	if len(data) < 20 {
+		return "", 0, nil, errFormat
+	}
+	var magic [4]byte
+	copy(magic[:], data)
+	if magic != mappedIndexMagic {
+		return "", 0, nil, errFormat
+	}
+	version := binary.LittleEndian.Uint32(data[4:])
+	if version != mappedIndexFormatVersion {
+		return "", 0, nil, fmt.Errorf("mapped search index format version %d is not supported, expecting version %d",
+			version, mappedIndexFormatVersion)
+	}
+	threshold := math.Float64frombits(binary.LittleEndian.Uint64(data[8:]))
+	nameLen := uint64(binary.LittleEndian.Uint32(data[16:]))
+	data = data[20:]
+	if uint64(len(data)) < nameLen+32 {
+		return "", 0, nil, errFormat
+	}
+	name := string(data[:nameLen])
+	data = data[nameLen:]
+	numSets := binary.LittleEndian.Uint64(data)
+	numSetTokens := binary.LittleEndian.Uint64(data[8:])
+	numTokens := binary.LittleEndian.Uint64(data[16:])
+	numEntries := binary.LittleEndian.Uint64(data[24:])
+	data = data[32:]
+	// Each count is no more than the number of bytes, so the section sizes
+	// below do not overflow.
+	for _, count := range []uint64{numSets, numSetTokens, numTokens, numEntries} {
+		if count > uint64(len(data)) {
+			return "", 0, nil, errFormat
+		}
+	}
+	// Split the sections, checking their sizes add up to the file size.
+	sizes := []uint64{
+		numSets,
+		(numSets + 1) * 8,
+		numSetTokens * 4,
+		numTokens * 4,
+		(numTokens + 1) * 8,
+		numEntries * mappedEntrySize,
+	}
+	var total uint64
+	for _, size := range sizes {
+		total += size
+	}
+	if total != uint64(len(data)) {
+		return "", 0, nil, errFormat
+	}
+	sections := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		sections[i], data = data[:size], data[size:]
+	}
+	m := &mappedIndex{
+		numSets:        int(numSets),
+		numTokens:      int(numTokens),
+		removed:        sections[0],
+		setOffsets:     sections[1],
+		setTokens:      sections[2],
+		tokens:         sections[3],
+		postingOffsets: sections[4],
+		entries:        sections[5],
+	}
+	// Check the offsets are in range, and the tokens and entries are valid,
+	// so accessing sets and posting lists never goes out of bounds.
+	for x, prev := 0, 0; x <= m.numSets; x++ {
+		offset := m.setOffset(x)
+		if offset < prev || offset > int(numSetTokens) {
+			return "", 0, nil, errFormat
+		}
+		prev = offset
+	}
+	for i, prev := 0, uint64(0); i <= m.numTokens; i++ {
+		offset := binary.LittleEndian.Uint64(m.postingOffsets[i*8:])
+		if offset < prev || offset > numEntries {
+			return "", 0, nil, errFormat
+		}
+		prev = offset
+	}
+	for i := 1; i < m.numTokens; i++ {
+		if m.token(i) <= m.token(i-1) {
+			return "", 0, nil, errFormat
+		}
+	}
+	for i := 0; i < m.numTokens; i++ {
+		pl := m.postingListAt(i)
+		for k := 0; k < pl.len(); k++ {
+			entry := pl.at(k)
+			x := entry.setIndex
+			if x >= m.numSets || m.isRemoved(x) ||
+				entry.setSize != m.setOffset(x+1)-m.setOffset(x) ||
+				entry.tokenPosition >= entry.setSize {
+				return "", 0, nil, errFormat
+			}
+		}
+	}
+	return name, threshold, m, nil
+}
+
+// WriteMappable writes the search index to w in a flat file format that can
+// be opened by OpenMappedSearchIndex without loading it into memory.
+// The tokens and sets must fit in 32-bit unsigned integers.  As with WriteTo,
+// only the name of the similarity function is written.
+func (si *SearchIndex) WriteMappable(w io.Writer) (int64, error) {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	// Check the sets and posting lists fit in the format.
+	if si.numSets() > math.MaxUint32 {
+		return 0, errors.New("too many sets for the mapped search index format")
+	}
+	var numSetTokens int
+	var buf []int
+	for x := 0; x < si.numSets(); x++ {
+		if si.isRemoved(x) {
+			continue
+		}
+		buf = si.set(x, buf)
+		for _, token := range buf {
+			if token < 0 || token > math.MaxUint32 {
+				return 0, fmt.Errorf("token %d is out of range for the mapped search index format", token)
+			}
+		}
+		numSetTokens += len(buf)
+	}
+	tokens := make([]int, 0)
+	var numEntries int
+	si.forEachPostingList(func(token int, pl postingList) {
+		tokens = append(tokens, token)
+		numEntries += pl.len()
+	})
+	sort.Ints(tokens)
+	// Write the header.
+	iw := newIndexWriter(w)
+	iw.write(mappedIndexMagic[:])
+	iw.writeUint32(mappedIndexFormatVersion)
+	iw.writeFloat64(si.threshold)
+	iw.writeUint32(uint32(len(si.similarityFunctionName)))
+	iw.write([]byte(si.similarityFunctionName))
+	iw.writeUint64(uint64(si.numSets()))
+	iw.writeUint64(uint64(numSetTokens))
+	iw.writeUint64(uint64(len(tokens)))
+	iw.writeUint64(uint64(numEntries))
+	// Write the sets.
+	for x := 0; x < si.numSets(); x++ {
+		if si.isRemoved(x) {
+			iw.write([]byte{1})
+		} else {
+			iw.write([]byte{0})
+		}
+	}
+	var offset uint64
+	iw.writeUint64(offset)
+	for x := 0; x < si.numSets(); x++ {
+		if !si.isRemoved(x) {
+			buf = si.set(x, buf)
+			offset += uint64(len(buf))
+		}
+		iw.writeUint64(offset)
+	}
+	for x := 0; x < si.numSets(); x++ {
+		if si.isRemoved(x) {
+			continue
+		}
+		buf = si.set(x, buf)
+		for _, token := range buf {
+			iw.writeUint32(uint32(token))
+		}
+	}
+	// Write the posting lists.
+	for _, token := range tokens {
+		iw.writeUint32(uint32(token))
+	}
+	offset = 0
+	iw.writeUint64(offset)
+	for _, token := range tokens {
+		offset += uint64(si.postingList(token).len())
+		iw.writeUint64(offset)
+	}
+	for _, token := range tokens {
+		pl := si.postingList(token)
+		for i := 0; i < pl.len(); i++ {
+			entry := pl.at(i)
+			iw.writeUint32(uint32(entry.setIndex))
+			iw.writeUint32(uint32(entry.tokenPosition))
+			iw.writeUint32(uint32(entry.setSize))
+		}
+	}
+	return iw.flush()
+}
+
+// OpenMappedSearchIndex opens a search index file written by
+// SearchIndex.WriteMappable using memory-mapping, so the sets and posting
+// lists are read from the file on demand, and processes opening the same file
+// share the page cache.
+// The search index is read-only until it is modified by Add, Remove or
+// Update, which first copy the index into memory.
+// Close must be called to release the mapping.
+func OpenMappedSearchIndex(filename string) (*SearchIndex, error) {
+	data, unmap, err := mapFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	name, threshold, m, err := readMappedIndex(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	si, err := newEmptySearchIndex(name, threshold)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("search index uses similarity function %q with threshold %v: %v",
+			name, threshold, err)
+	}
+	m.unmap = unmap
+	si.mapped = m
+	return si, nil
+}
+
+// materialize copies a mapped search index into memory, so it can be
+// modified, and releases the mapping.
+func (si *SearchIndex) materialize() {
+	if si.mapped == nil {
+		return
+	}
+	m := si.mapped
+	si.sets = make([][]int, m.numSets)
+	si.removed = make([]bool, m.numSets)
+	for x := range si.sets {
+		si.removed[x] = m.isRemoved(x)
+		if !si.removed[x] {
+			si.sets[x] = m.set(x, nil)
+		}
+	}
+	m.forEachPostingList(func(token int, pl postingList) {
+		entries := make([]postingListEntry, pl.len())
+		for i := range entries {
+			entries[i] = pl.at(i)
+		}
+		si.postingLists[token] = entries
+	})
+	si.mapped = nil
+	m.unmap()
+}
+
+// Close releases the mapping of a search index opened by
+// OpenMappedSearchIndex, after which the search index must not be used.
+// It does nothing for other search indexes.
+func (si *SearchIndex) Close() error {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	if si.mapped == nil {
+		return nil
+	}
+	m := si.mapped
+	si.mapped = nil
+	si.sets = nil
+	si.removed = nil
+	return m.unmap()
+}
@@ -0,0 +1,86 @@
+package SetSimilaritySearch
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMappedSearchIndex(t *testing.T) {
+	sets := randomSets(300, 20, 50, 6)
+	searchIndex, err := NewSearchIndex(sets[:200], "cosine", 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := searchIndex.Remove(5); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "index.sssm")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := searchIndex.WriteMappable(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := OpenMappedSearchIndex(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapped.Close()
+	for _, query := range sets {
+		checkResults(t, mapped.Query(query), searchIndex.Query(query))
+		results := mapped.QueryTopK(query, 3)
+		correctResults := searchIndex.QueryTopK(query, 3)
+		if len(results) != len(correctResults) {
+			t.Fatalf("Expecting %v got %v", correctResults, results)
+		}
+		for i := range results {
+			if results[i] != correctResults[i] {
+				t.Errorf("Expecting %v got %v", correctResults, results)
+			}
+		}
+	}
+	// Modifying the mapped index copies it into memory.
+	if err := mapped.Remove(5); err == nil {
+		t.Error("Expecting removed set to stay removed")
+	}
+	for _, s := range sets[200:] {
+		mapped.Add(s)
+		searchIndex.Add(s)
+	}
+	for _, query := range sets {
+		checkResults(t, mapped.Query(query), searchIndex.Query(query))
+	}
+
+	// Corrupted entries, with the set index, token position or set size of
+	// the last posting list entry changed.
+	var buf bytes.Buffer
+	if _, err := searchIndex.WriteMappable(&buf); err != nil {
+		t.Fatal(err)
+	}
+	corruptedFilename := filepath.Join(t.TempDir(), "corrupted.sssm")
+	for _, offset := range []int{0, 3, 7, 8} {
+		corrupted := append([]byte(nil), buf.Bytes()...)
+		corrupted[len(corrupted)-mappedEntrySize+offset] ^= 0xff
+		if err := os.WriteFile(corruptedFilename, corrupted, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenMappedSearchIndex(corruptedFilename); err == nil {
+			t.Errorf("Expecting error opening a file with byte %d of an entry corrupted",
+				offset)
+		}
+	}
+
+	// Invalid file.
+	if err := os.WriteFile(filename, []byte("SSSI"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenMappedSearchIndex(filename); err == nil {
+		t.Error("Expecting error opening an invalid file")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package SetSimilaritySearch
+
+import "os"
+
+// mapFile reads the file into memory on platforms without memory-mapping
+// support, and returns the data and a function that releases it.
+func mapFile(filename string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
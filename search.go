@@ -1,68 +1,237 @@
 package SetSimilaritySearch
 
 import (
+	"container/heap"
 	"errors"
+	"fmt"
+	"runtime"
 	"sort"
+	"sync"
 )
 
 // SearchIndex is a data structure supports set similarity search queries.
 // The algorithm is a combination of the prefix filter and position filter
 // techniques.
+// Sets can be added, removed and updated after the index is built.
+// A SearchIndex is safe for concurrent use by multiple goroutines: queries
+// run concurrently with each other, and modifications wait for the running
+// queries to finish.
 type SearchIndex struct {
+	mu                        sync.RWMutex
+	similarityFunctionName    string
 	threshold                 float64
 	simFunc                   function
+	overlapFunc               overlapFunction
 	overlapThresholdFunc      overlapThresholdFunction
 	overlapIndexThresholdFunc overlapThresholdFunction
 	positionFilterFunc        positionFilter
+	pairOverlapThresholdFunc  pairOverlapThresholdFunction
+	sizeBoundsFunc            sizeBoundsFunction
+	suffixFilterDepth         int
 	sets                      [][]int
+	removed                   []bool
 	postingLists              map[int][]postingListEntry
+	// mapped is set for a search index opened from a mapped file, in which
+	// case sets, removed and postingLists are not used until the index is
+	// modified.
+	mapped *mappedIndex
 }
 
 // NewSearchIndex builds a search index on the transformed sets given
 // the similarity function and threshold.
-// Currently supported similarity functions are "jaccard", "cosine"
-// and "containment".
+// Currently supported similarity functions are "jaccard", "cosine",
+// "dice", "overlap", "intersection_size" and "containment".
 func NewSearchIndex(sets [][]int, similarityFunctionName string,
 	similarityThreshold float64) (*SearchIndex, error) {
 	if len(sets) == 0 {
 		return nil, errors.New("input sets cannot be empty")
 	}
-	if similarityThreshold < 0 || similarityThreshold > 1.0 {
-		return nil, errors.New("input similarityThreshold must be in the range [0, 1]")
+	si, err := newEmptySearchIndex(similarityFunctionName, similarityThreshold)
+	if err != nil {
+		return nil, err
 	}
+	// Copy the slice of sets so adding sets does not write to the
+	// input slice.
+	si.sets = append([][]int(nil), sets...)
+	si.removed = make([]bool, len(sets))
+	// Index transformed sets.
+	for i, s := range sets {
+		for j, token := range si.indexPrefix(s) {
+			si.postingLists[token] = append(si.postingLists[token],
+				postingListEntry{i, j, len(s)})
+		}
+	}
+	// Sort each posting lists by set size for length filter.
+	for _, postingList := range si.postingLists {
+		sort.SliceStable(postingList, func(i, j int) bool {
+			return postingList[i].setSize < postingList[j].setSize
+		})
+	}
+	return si, nil
+}
+
+// newEmptySearchIndex creates a search index with no sets given the
+// similarity function and threshold.
+func newEmptySearchIndex(similarityFunctionName string,
+	similarityThreshold float64) (*SearchIndex, error) {
 	si := SearchIndex{
-		threshold:    similarityThreshold,
-		sets:         sets,
-		postingLists: make(map[int][]postingListEntry),
+		similarityFunctionName: similarityFunctionName,
+		threshold:              similarityThreshold,
+		postingLists:           make(map[int][]postingListEntry),
 	}
 	if f, exists := similarityFuncs[similarityFunctionName]; exists {
 		si.simFunc = f
 	} else {
 		return nil, errors.New("input similarityFunctionName is not supported")
 	}
+	if err := checkThreshold(similarityFunctionName, similarityThreshold); err != nil {
+		return nil, err
+	}
+	si.overlapFunc = overlapFuncs[similarityFunctionName]
 	si.overlapThresholdFunc = overlapThresholdFuncs[similarityFunctionName]
 	si.overlapIndexThresholdFunc = overlapIndexThresholdFuncs[similarityFunctionName]
 	si.positionFilterFunc = positionFilterFuncs[similarityFunctionName]
-	// Index transformed sets.
-	for i, s := range sets {
-		t := si.overlapIndexThresholdFunc(len(s), si.threshold)
-		prefixSize := len(s) - t + 1
-		prefix := s[:prefixSize]
-		for j, token := range prefix {
-			if _, exists := si.postingLists[token]; !exists {
-				si.postingLists[token] = make([]postingListEntry, 0)
-			}
-			si.postingLists[token] = append(si.postingLists[token],
-				postingListEntry{i, j, len(s)})
-		}
+	si.pairOverlapThresholdFunc = pairOverlapThresholdFuncs[similarityFunctionName]
+	si.sizeBoundsFunc = sizeBoundsFuncs[similarityFunctionName]
+	return &si, nil
+}
+
+// numSets returns the number of sets including the removed ones.
+func (si *SearchIndex) numSets() int {
+	if si.mapped != nil {
+		return si.mapped.numSets
 	}
-	// Sort each posting lists by set size for length filter.
-	for token, postingList := range si.postingLists {
-		sort.Slice(si.postingLists[token], func(i, j int) bool {
-			return postingList[i].setSize < postingList[j].setSize
+	return len(si.sets)
+}
+
+// isRemoved returns whether the set x has been removed.
+func (si *SearchIndex) isRemoved(x int) bool {
+	if si.mapped != nil {
+		return si.mapped.isRemoved(x)
+	}
+	return si.removed[x]
+}
+
+// set returns the set x.  For a mapped search index, the set is decoded into
+// buf, which is grown if needed.
+func (si *SearchIndex) set(x int, buf []int) []int {
+	if si.mapped != nil {
+		return si.mapped.set(x, buf)
+	}
+	return si.sets[x]
+}
+
+// postingList returns the posting list of the token.
+func (si *SearchIndex) postingList(token int) postingList {
+	if si.mapped != nil {
+		return si.mapped.postingList(token)
+	}
+	return postingList{entries: si.postingLists[token]}
+}
+
+// forEachPostingList calls f with every token and its posting list.
+func (si *SearchIndex) forEachPostingList(f func(token int, pl postingList)) {
+	if si.mapped != nil {
+		si.mapped.forEachPostingList(f)
+		return
+	}
+	for token, entries := range si.postingLists {
+		f(token, postingList{entries: entries})
+	}
+}
+
+// indexPrefix returns the tokens of a set that are indexed in the posting
+// lists.
+func (si *SearchIndex) indexPrefix(s []int) []int {
+	t := si.overlapIndexThresholdFunc(len(s), si.threshold)
+	prefixSize := len(s) - t + 1
+	return s[:prefixSize]
+}
+
+// insert adds the prefix of the set x to the posting lists, keeping each
+// posting list sorted by set size.
+func (si *SearchIndex) insert(x int) {
+	s := si.sets[x]
+	for j, token := range si.indexPrefix(s) {
+		postingList := si.postingLists[token]
+		// Insert after the entries with the same set size.
+		i := sort.Search(len(postingList), func(i int) bool {
+			return postingList[i].setSize > len(s)
 		})
+		postingList = append(postingList, postingListEntry{})
+		copy(postingList[i+1:], postingList[i:])
+		postingList[i] = postingListEntry{x, j, len(s)}
+		si.postingLists[token] = postingList
+	}
+}
+
+// delete removes the prefix of the set x from the posting lists.
+func (si *SearchIndex) delete(x int) {
+	s := si.sets[x]
+	for _, token := range si.indexPrefix(s) {
+		postingList := si.postingLists[token]
+		// Find the entry among the ones with the same set size.
+		i := sort.Search(len(postingList), func(i int) bool {
+			return postingList[i].setSize >= len(s)
+		})
+		for ; i < len(postingList) && postingList[i].setIndex != x; i++ {
+		}
+		if i == len(postingList) {
+			continue
+		}
+		postingList = append(postingList[:i], postingList[i+1:]...)
+		if len(postingList) == 0 {
+			delete(si.postingLists, token)
+		} else {
+			si.postingLists[token] = postingList
+		}
 	}
-	return &si, nil
+}
+
+// Add inserts a transformed set into the search index, and returns the
+// index of the new set that is used in SearchResult.
+// For a search index opened by OpenMappedSearchIndex, the first
+// modification copies the index into memory.
+func (si *SearchIndex) Add(s []int) int {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.materialize()
+	x := len(si.sets)
+	si.sets = append(si.sets, s)
+	si.removed = append(si.removed, false)
+	si.insert(x)
+	return x
+}
+
+// Remove deletes the set with the given index from the search index.
+// The index of a removed set is never reused, and subsequent queries never
+// return it.
+func (si *SearchIndex) Remove(x int) error {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.materialize()
+	if x < 0 || x >= len(si.sets) || si.removed[x] {
+		return errors.New("input set index does not exist")
+	}
+	si.delete(x)
+	si.sets[x] = nil
+	si.removed[x] = true
+	return nil
+}
+
+// Update replaces the set with the given index by a new transformed set,
+// keeping the index of the set unchanged.
+func (si *SearchIndex) Update(x int, s []int) error {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.materialize()
+	if x < 0 || x >= len(si.sets) || si.removed[x] {
+		return errors.New("input set index does not exist")
+	}
+	si.delete(x)
+	si.sets[x] = s
+	si.insert(x)
+	return nil
 }
 
 // SearchResult corresponding a set found from a query.
@@ -77,39 +246,231 @@ type SearchResult struct {
 // This function takes a transformed set and
 // returns a slice of SearchResult that contain the indexes of the sets found.
 func (si *SearchIndex) Query(s []int) []SearchResult {
-	t := si.overlapThresholdFunc(len(s), si.threshold)
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return si.query(s, si.threshold)
+}
+
+// QueryBatch is the same as calling Query for each of the query sets, but
+// uses multiple workers to run the queries concurrently.
+// If workers is less than 1, runtime.NumCPU() workers are used.
+// It returns a slice of results for each query set in the same order as the
+// query sets.
+func (si *SearchIndex) QueryBatch(queries [][]int, workers int) [][]SearchResult {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	results := make([][]SearchResult, len(queries))
+	next := make(chan int)
+	go func() {
+		defer close(next)
+		for i := range queries {
+			next <- i
+		}
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = si.query(queries[i], si.threshold)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// QueryWithThreshold is the same as Query, but uses the given similarity
+// threshold instead of the one specified for the index.  The threshold
+// must not be lower than the index's, because the indexed prefixes are
+// only long enough for the index's threshold.  A higher threshold uses a
+// shorter query prefix and tighter filters.
+func (si *SearchIndex) QueryWithThreshold(s []int,
+	similarityThreshold float64) ([]SearchResult, error) {
+	maxThreshold := maxThresholds[si.similarityFunctionName]
+	if similarityThreshold < si.threshold || similarityThreshold > maxThreshold {
+		return nil, fmt.Errorf("input similarityThreshold must be in the range [index threshold, %v]",
+			maxThreshold)
+	}
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return si.query(s, similarityThreshold), nil
+}
+
+func (si *SearchIndex) query(s []int, threshold float64) []SearchResult {
+	t := si.overlapThresholdFunc(len(s), threshold)
 	prefixSize := len(s) - t + 1
 	prefix := s[:prefixSize]
-	// Find candidates using tokens in the prefix.
-	candidates := make([]int, 0)
+	// Find candidates using tokens in the prefix, and accumulate their
+	// overlaps.
+	acc := newAccumulator()
+	var buf []int
+	lower, upper := si.sizeBoundsFunc(len(s), threshold)
 	for p1, token := range prefix {
-		// TODO: use binary search to find starting position.
-		// TODO: stops at an ending position for symmetric function.
-		for _, entry := range si.postingLists[token] {
-			if si.positionFilterFunc(s, si.sets[entry.setIndex], p1,
-				entry.tokenPosition, si.threshold) {
-				candidates = append(candidates, entry.setIndex)
+		// Scan only the entries of sets within the size bounds.
+		pl := si.postingList(token)
+		start, end := pl.sizeRange(lower, upper)
+		for i := start; i < end; i++ {
+			entry := pl.at(i)
+			x2 := entry.setIndex
+			c, exists := acc.get(x2)
+			switch {
+			case c.pruned:
+				continue
+			case exists:
+				c.overlap++
+				c.p1, c.p2 = p1, entry.tokenPosition
+			case !si.positionFilterFunc(len(s), entry.setSize, p1,
+				entry.tokenPosition, threshold):
+				// The first matching token is too far behind.
+				c.pruned = true
+			case si.suffixFilterDepth > 0 && !si.suffixFilterPasses(s,
+				si.set(x2, buf), p1, entry.tokenPosition, threshold):
+				c.pruned = true
+			default:
+				c = candidate{overlap: 1, p1: p1, p2: entry.tokenPosition}
+			}
+			if !c.pruned && c.overlap+c.remaining(len(s), entry.setSize) <
+				si.pairOverlapThresholdFunc(len(s), entry.setSize, threshold) {
+				c.pruned = true
 			}
+			acc.set(x2, c)
 		}
 	}
-	// Sort and iterate through candidate indexes to verify
-	// pairs.
-	// TODO: optimize using partial overlaps.
-	sort.Ints(candidates)
+	// Verify the candidates, continuing from their last matching tokens.
 	results := make([]SearchResult, 0)
-	prevCandidate := -1
-	for _, x2 := range candidates {
-		// Skip seen candidate.
-		if x2 == prevCandidate {
+	for _, x2 := range acc.order {
+		c, _ := acc.get(x2)
+		if c.pruned {
 			continue
 		}
-		prevCandidate = x2
-		// Compute the exact similarity of this candidate
-		sim := si.simFunc(s, si.sets[x2])
-		if sim < si.threshold {
+		buf = si.set(x2, buf)
+		sim := si.overlapFunc(c.verify(s, buf), len(s), len(buf))
+		if sim < threshold {
 			continue
 		}
 		results = append(results, SearchResult{x2, sim})
 	}
 	return results
 }
+
+// SetSuffixFilter enables the suffix filter of the PPJoin+ algorithm in the
+// paper "Efficient Similarity Joins for Near Duplicate Detection" by Xiao et
+// al., if maxDepth is positive, or disables it otherwise.
+// The suffix filter prunes a candidate at its first matching token if a
+// lower bound of the number of different tokens after it, computed by
+// recursively partitioning the sets up to maxDepth times, is too high for
+// the similarity threshold.  It saves verifying candidates that share
+// prefix tokens but not enough other tokens, at the cost of a few binary
+// searches per candidate.
+func (si *SearchIndex) SetSuffixFilter(maxDepth int) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.suffixFilterDepth = maxDepth
+}
+
+// suffixFilterPasses applies the suffix filter to the query set s1 and the
+// indexed set s2 with the first matching token at p1 and p2.
+func (si *SearchIndex) suffixFilterPasses(s1, s2 []int, p1, p2 int,
+	threshold float64) bool {
+	overlap := si.pairOverlapThresholdFunc(len(s1), len(s2), threshold)
+	return suffixFilterPasses(s1, s2, p1, p2, overlap, si.suffixFilterDepth)
+}
+
+// searchResultHeap is a min-heap of search results ordered by similarity.
+type searchResultHeap []SearchResult
+
+func (h searchResultHeap) Len() int { return len(h) }
+func (h searchResultHeap) Less(i, j int) bool {
+	return h[i].Similarity < h[j].Similarity
+}
+func (h searchResultHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *searchResultHeap) Push(x interface{}) {
+	*h = append(*h, x.(SearchResult))
+}
+
+func (h *searchResultHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// QueryTopK probes the search index for the k sets that are the most similar
+// to the query set, among the sets whose similarity with the query set are
+// at or above the similarity threshold specified for the index.
+// This function takes a transformed set and returns a slice of at most k
+// SearchResult sorted by similarity in descending order.
+// Build the index with threshold 0 to get the k most similar sets regardless
+// of threshold, among the sets sharing a token with the query set.
+// Once k results are found, the similarity of the k-th result is used as the
+// threshold for the rest of the query, so the query prefix shrinks and more
+// candidates are pruned by the position filter as better results are found.
+// Like Query, it does not return an error, so if k is not positive it
+// returns an empty slice, unlike AllPairsTopK which returns an error.
+func (si *SearchIndex) QueryTopK(s []int, k int) []SearchResult {
+	if k <= 0 {
+		return make([]SearchResult, 0)
+	}
+	results := make(searchResultHeap, 0, k)
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	threshold := si.threshold
+	verified := make(map[int]bool)
+	var buf []int
+	for p1, token := range s {
+		// Stop at the end of the prefix for the current threshold.
+		t := si.overlapThresholdFunc(len(s), threshold)
+		if p1 >= len(s)-t+1 {
+			break
+		}
+		// Skip the sets outside the size bounds for the current threshold.
+		lower, upper := si.sizeBoundsFunc(len(s), threshold)
+		pl := si.postingList(token)
+		start, end := pl.sizeRange(lower, upper)
+		for i := start; i < end; i++ {
+			entry := pl.at(i)
+			x2 := entry.setIndex
+			if verified[x2] {
+				continue
+			}
+			if !si.positionFilterFunc(len(s), entry.setSize, p1,
+				entry.tokenPosition, threshold) {
+				continue
+			}
+			verified[x2] = true
+			buf = si.set(x2, buf)
+			if si.suffixFilterDepth > 0 && !si.suffixFilterPasses(s, buf, p1,
+				entry.tokenPosition, threshold) {
+				continue
+			}
+			// Compute the exact similarity of this candidate
+			sim := si.simFunc(s, buf)
+			if sim < threshold {
+				continue
+			}
+			if len(results) < k {
+				heap.Push(&results, SearchResult{x2, sim})
+			} else if sim > results[0].Similarity {
+				results[0] = SearchResult{x2, sim}
+				heap.Fix(&results, 0)
+			}
+			// Raise the threshold to the similarity of the k-th result.
+			if len(results) == k && results[0].Similarity > threshold {
+				threshold = results[0].Similarity
+			}
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Similarity != results[j].Similarity {
+			return results[i].Similarity > results[j].Similarity
+		}
+		return results[i].X < results[j].X
+	})
+	return results
+}
@@ -1,6 +1,10 @@
 package SetSimilaritySearch
 
-import "testing"
+import (
+	"sort"
+	"sync"
+	"testing"
+)
 
 func resultExists(r SearchResult, results []SearchResult) bool {
 	for i := range results {
@@ -90,3 +94,250 @@ func TestSearchIndexContainment(t *testing.T) {
 			len(results))
 	}
 }
+
+func TestSearchIndexDice(t *testing.T) {
+	sets := [][]int{
+		[]int{1, 2, 3},
+		[]int{3, 4, 5},
+		[]int{2, 3, 4},
+		[]int{5, 6, 7},
+	}
+	query := []int{3, 4, 5}
+	correctResults := []SearchResult{
+		SearchResult{1, 1.0},
+		SearchResult{2, 2.0 * 2.0 / 6.0},
+	}
+	searchIndex, err := NewSearchIndex(sets, "dice", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, searchIndex.Query(query), correctResults)
+	// Compare with brute force on random sets.
+	sets = randomSets(300, 20, 50, 16)
+	queries := randomSets(50, 20, 50, 17)
+	for _, threshold := range []float64{0.3, 0.5, 0.8} {
+		searchIndex, err := NewSearchIndex(sets, "dice", threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, query := range queries {
+			checkResults(t, searchIndex.Query(query),
+				bruteForceQuery(sets, nil, query, dice, threshold))
+		}
+	}
+}
+
+func TestSearchIndexOverlap(t *testing.T) {
+	sets := randomSets(300, 20, 50, 18)
+	queries := randomSets(50, 20, 50, 19)
+	for _, c := range []struct {
+		function  string
+		simFunc   function
+		threshold float64
+	}{
+		{"overlap", overlapCoefficient, 0.5},
+		{"overlap", overlapCoefficient, 0.9},
+		{"intersection_size", intersectionSizeSimilarity, 1},
+		{"intersection_size", intersectionSizeSimilarity, 5},
+		{"intersection_size", intersectionSizeSimilarity, 8.5},
+	} {
+		searchIndex, err := NewSearchIndex(sets, c.function, c.threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, query := range queries {
+			checkResults(t, searchIndex.Query(query),
+				bruteForceQuery(sets, nil, query, c.simFunc, c.threshold))
+		}
+	}
+	if _, err := NewSearchIndex(sets, "overlap", 1.5); err == nil {
+		t.Error("Expecting an error for an overlap threshold above 1")
+	}
+	if _, err := NewSearchIndex(sets, "intersection_size", -1); err == nil {
+		t.Error("Expecting an error for a negative intersection size threshold")
+	}
+	// Sets smaller than the threshold have no results.
+	searchIndex, err := NewSearchIndex(sets, "intersection_size", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results := searchIndex.Query(sets[0]); len(results) != 0 {
+		t.Errorf("Expecting no results, got %v", results)
+	}
+}
+
+// bruteForceQuery returns the results of a query by computing the
+// similarity with every set.
+func bruteForceQuery(sets [][]int, removed map[int]bool, s []int,
+	simFunc function, threshold float64) []SearchResult {
+	results := make([]SearchResult, 0)
+	for x, set := range sets {
+		if removed[x] {
+			continue
+		}
+		if sim := simFunc(s, set); sim >= threshold {
+			results = append(results, SearchResult{x, sim})
+		}
+	}
+	return results
+}
+
+func checkResults(t *testing.T, results, correctResults []SearchResult) {
+	for _, r := range results {
+		if !resultExists(r, correctResults) {
+			t.Errorf("The result %v is not correct", r)
+		}
+	}
+	if len(results) != len(correctResults) {
+		t.Errorf("Expecting %d results got %d", len(correctResults),
+			len(results))
+	}
+}
+
+func TestSearchIndexUpdates(t *testing.T) {
+	sets := randomSets(200, 20, 50, 1)
+	newSets := randomSets(100, 20, 50, 2)
+	for _, function := range []string{"jaccard", "cosine", "containment"} {
+		searchIndex, err := NewSearchIndex(sets[:100], function, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current := append([][]int(nil), sets[:100]...)
+		removed := make(map[int]bool)
+		for _, s := range sets[100:] {
+			if x := searchIndex.Add(s); x != len(current) {
+				t.Errorf("Expecting new set index %d got %d", len(current), x)
+			}
+			current = append(current, s)
+		}
+		for x := 0; x < len(current); x += 3 {
+			if err := searchIndex.Remove(x); err != nil {
+				t.Fatal(err)
+			}
+			removed[x] = true
+		}
+		for x := 1; x < len(current); x += 3 {
+			if err := searchIndex.Update(x, newSets[x/3]); err != nil {
+				t.Fatal(err)
+			}
+			current[x] = newSets[x/3]
+		}
+		if err := searchIndex.Remove(0); err == nil {
+			t.Error("Expecting error when removing a removed set")
+		}
+		if err := searchIndex.Update(len(current), newSets[0]); err == nil {
+			t.Error("Expecting error when updating a non-existent set")
+		}
+		for _, query := range append(sets, newSets...) {
+			checkResults(t, searchIndex.Query(query), bruteForceQuery(current,
+				removed, query, similarityFuncs[function], 0.5))
+		}
+	}
+}
+
+func TestSearchIndexQueryTopK(t *testing.T) {
+	sets := randomSets(300, 20, 50, 3)
+	for _, function := range []string{"jaccard", "cosine", "containment"} {
+		// An index with threshold 0 gives the top k among all sets sharing
+		// a token with the query set.
+		for _, threshold := range []float64{0, 0.2} {
+			searchIndex, err := NewSearchIndex(sets, function, threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, query := range sets[:50] {
+				correctResults := make([]SearchResult, 0)
+				for _, r := range bruteForceQuery(sets, nil, query,
+					similarityFuncs[function], threshold) {
+					if r.Similarity > 0 {
+						correctResults = append(correctResults, r)
+					}
+				}
+				sort.Slice(correctResults, func(i, j int) bool {
+					return correctResults[i].Similarity > correctResults[j].Similarity
+				})
+				for _, k := range []int{1, 5, 20, 300} {
+					results := searchIndex.QueryTopK(query, k)
+					if len(results) != min(k, len(correctResults)) {
+						t.Fatalf("Expecting %d results got %d",
+							min(k, len(correctResults)), len(results))
+					}
+					for i, r := range results {
+						if r.Similarity != correctResults[i].Similarity {
+							t.Errorf("Expecting similarity %f at rank %d got %v",
+								correctResults[i].Similarity, i, r)
+						}
+						if i > 0 && r.Similarity > results[i-1].Similarity {
+							t.Errorf("Results not in descending order: %v", results)
+						}
+						if !resultExists(r, correctResults) {
+							t.Errorf("The result %v is not correct", r)
+						}
+					}
+				}
+			}
+			for _, k := range []int{0, -1} {
+				if results := searchIndex.QueryTopK(sets[0], k); len(results) != 0 {
+					t.Errorf("Expecting no results for k %d got %v", k, results)
+				}
+			}
+		}
+	}
+}
+
+func TestSearchIndexQueryWithThreshold(t *testing.T) {
+	sets := randomSets(300, 20, 50, 4)
+	for _, function := range []string{"jaccard", "cosine", "containment"} {
+		searchIndex, err := NewSearchIndex(sets, function, 0.2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, threshold := range []float64{0.2, 0.5, 0.8, 1.0} {
+			for _, query := range sets[:50] {
+				results, err := searchIndex.QueryWithThreshold(query, threshold)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkResults(t, results, bruteForceQuery(sets, nil, query,
+					similarityFuncs[function], threshold))
+			}
+		}
+		if _, err := searchIndex.QueryWithThreshold(sets[0], 0.1); err == nil {
+			t.Error("Expecting error for threshold lower than the index's")
+		}
+	}
+}
+
+func TestSearchIndexQueryBatch(t *testing.T) {
+	sets := randomSets(300, 20, 50, 7)
+	searchIndex, err := NewSearchIndex(sets, "containment", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{0, 1, 4} {
+		batchResults := searchIndex.QueryBatch(sets, workers)
+		if len(batchResults) != len(sets) {
+			t.Fatalf("Expecting %d results got %d", len(sets),
+				len(batchResults))
+		}
+		for i, results := range batchResults {
+			checkResults(t, results, searchIndex.Query(sets[i]))
+		}
+	}
+	// Query concurrently with modifications.
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, query := range sets[:50] {
+				searchIndex.Query(query)
+				searchIndex.QueryTopK(query, 5)
+			}
+		}()
+	}
+	for _, s := range sets[:50] {
+		searchIndex.Add(s)
+	}
+	wg.Wait()
+}
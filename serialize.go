@@ -0,0 +1,414 @@
+package SetSimilaritySearch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// searchIndexMagic identifies the binary format of a search index.
+var searchIndexMagic = [4]byte{'S', 'S', 'S', 'I'}
+
+// searchIndexFormatVersion is the version of the binary format written by
+// SearchIndex.WriteTo.  It must be incremented when the format changes.
+const searchIndexFormatVersion = 1
+
+// dictionaryMagic identifies the binary format of a dictionary.
+var dictionaryMagic = [4]byte{'S', 'S', 'S', 'D'}
+
+// dictionaryFormatVersion is the version of the binary format written by
+// Dictionary.WriteTo.  It must be incremented when the format changes.
+const dictionaryFormatVersion = 1
+
+// maxPreallocation limits the capacity allocated up front for slices read
+// from a binary format, so corrupted lengths fail at the checksum rather
+// than exhausting memory.
+const maxPreallocation = 1 << 16
+
+// indexWriter writes varint-encoded values and keeps track of the number of
+// bytes written and the checksum.
+type indexWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	n   int64
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func newIndexWriter(w io.Writer) *indexWriter {
+	return &indexWriter{
+		w:   bufio.NewWriter(w),
+		crc: crc32.NewIEEE(),
+	}
+}
+
+func (iw *indexWriter) write(p []byte) {
+	if iw.err != nil {
+		return
+	}
+	n, err := iw.w.Write(p)
+	iw.crc.Write(p[:n])
+	iw.n += int64(n)
+	iw.err = err
+}
+
+func (iw *indexWriter) writeUvarint(x uint64) {
+	n := binary.PutUvarint(iw.buf[:], x)
+	iw.write(iw.buf[:n])
+}
+
+func (iw *indexWriter) writeVarint(x int64) {
+	n := binary.PutVarint(iw.buf[:], x)
+	iw.write(iw.buf[:n])
+}
+
+func (iw *indexWriter) writeUint32(x uint32) {
+	binary.LittleEndian.PutUint32(iw.buf[:4], x)
+	iw.write(iw.buf[:4])
+}
+
+func (iw *indexWriter) writeUint64(x uint64) {
+	binary.LittleEndian.PutUint64(iw.buf[:8], x)
+	iw.write(iw.buf[:8])
+}
+
+func (iw *indexWriter) writeFloat64(x float64) {
+	binary.LittleEndian.PutUint64(iw.buf[:8], math.Float64bits(x))
+	iw.write(iw.buf[:8])
+}
+
+func (iw *indexWriter) writeString(s string) {
+	iw.writeUvarint(uint64(len(s)))
+	iw.write([]byte(s))
+}
+
+// writeSet writes a sorted set as delta-encoded tokens.
+func (iw *indexWriter) writeSet(s []int) {
+	iw.writeUvarint(uint64(len(s)))
+	prev := 0
+	for _, token := range s {
+		iw.writeVarint(int64(token - prev))
+		prev = token
+	}
+}
+
+// flush writes any buffered data, and returns the number of bytes written
+// and the first error encountered.
+func (iw *indexWriter) flush() (int64, error) {
+	if iw.err != nil {
+		return iw.n, iw.err
+	}
+	return iw.n, iw.w.Flush()
+}
+
+// close writes the checksum of everything written so far and flushes.
+func (iw *indexWriter) close() (int64, error) {
+	iw.writeUint32(iw.crc.Sum32())
+	return iw.flush()
+}
+
+// indexReader reads varint-encoded values and computes the checksum of the
+// bytes read.
+type indexReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+	buf [8]byte
+}
+
+func newIndexReader(r io.Reader) *indexReader {
+	return &indexReader{
+		r:   bufio.NewReader(r),
+		crc: crc32.NewIEEE(),
+	}
+}
+
+// ReadByte implements io.ByteReader for the binary varint functions.
+func (ir *indexReader) ReadByte() (byte, error) {
+	b, err := ir.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	ir.buf[0] = b
+	ir.crc.Write(ir.buf[:1])
+	return b, nil
+}
+
+func (ir *indexReader) read(p []byte) {
+	if ir.err != nil {
+		return
+	}
+	_, ir.err = io.ReadFull(ir.r, p)
+	ir.crc.Write(p)
+}
+
+func (ir *indexReader) readUvarint() uint64 {
+	if ir.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(ir)
+	ir.err = err
+	return x
+}
+
+func (ir *indexReader) readVarint() int64 {
+	if ir.err != nil {
+		return 0
+	}
+	x, err := binary.ReadVarint(ir)
+	ir.err = err
+	return x
+}
+
+// readLength reads a length and checks it is no more than max.
+func (ir *indexReader) readLength(max uint64) int {
+	x := ir.readUvarint()
+	if ir.err == nil && x > max {
+		ir.err = errors.New("invalid length in binary format")
+		return 0
+	}
+	return int(x)
+}
+
+func (ir *indexReader) readUint32() uint32 {
+	ir.read(ir.buf[:4])
+	return binary.LittleEndian.Uint32(ir.buf[:4])
+}
+
+func (ir *indexReader) readFloat64() float64 {
+	ir.read(ir.buf[:8])
+	return math.Float64frombits(binary.LittleEndian.Uint64(ir.buf[:8]))
+}
+
+func (ir *indexReader) readString() string {
+	n := ir.readLength(math.MaxInt32)
+	// Read in chunks, so a corrupted length fails at the end of the input
+	// rather than exhausting memory.
+	p := make([]byte, 0, min(n, maxPreallocation))
+	for len(p) < n && ir.err == nil {
+		chunk := make([]byte, min(n-len(p), maxPreallocation))
+		ir.read(chunk)
+		p = append(p, chunk...)
+	}
+	return string(p)
+}
+
+func (ir *indexReader) readSet() []int {
+	n := ir.readLength(math.MaxInt32)
+	s := make([]int, 0, min(n, maxPreallocation))
+	prev := 0
+	for i := 0; i < n && ir.err == nil; i++ {
+		prev += int(ir.readVarint())
+		s = append(s, prev)
+	}
+	return s
+}
+
+// checkChecksum reads the checksum stored after the bytes read so far and
+// compares it with the computed one.
+func (ir *indexReader) checkChecksum() error {
+	if ir.err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if ir.err != nil {
+		return ir.err
+	}
+	sum := ir.crc.Sum32()
+	if _, err := io.ReadFull(ir.r, ir.buf[:4]); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if binary.LittleEndian.Uint32(ir.buf[:4]) != sum {
+		return errors.New("checksum mismatch in binary format")
+	}
+	return nil
+}
+
+// WriteTo writes the search index in a versioned binary format to w,
+// including the similarity function name, the threshold, the sets and the
+// posting lists, followed by a checksum.  It implements io.WriterTo.
+// Use ReadSearchIndex to read the search index back.  Only the name of a
+// similarity function registered by RegisterSimilarity is written, so it
+// must be registered with the same parameters before reading.
+func (si *SearchIndex) WriteTo(w io.Writer) (int64, error) {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	iw := newIndexWriter(w)
+	iw.write(searchIndexMagic[:])
+	iw.writeUint32(searchIndexFormatVersion)
+	iw.writeString(si.similarityFunctionName)
+	iw.writeFloat64(si.threshold)
+	// Write the sets, an empty set is written for a removed set.
+	iw.writeUvarint(uint64(si.numSets()))
+	var buf []int
+	for x := 0; x < si.numSets(); x++ {
+		if si.isRemoved(x) {
+			iw.write([]byte{1})
+			iw.writeSet(nil)
+			continue
+		}
+		iw.write([]byte{0})
+		buf = si.set(x, buf)
+		iw.writeSet(buf)
+	}
+	// Write the posting lists.
+	numTokens := 0
+	si.forEachPostingList(func(int, postingList) { numTokens++ })
+	iw.writeUvarint(uint64(numTokens))
+	si.forEachPostingList(func(token int, pl postingList) {
+		iw.writeVarint(int64(token))
+		iw.writeUvarint(uint64(pl.len()))
+		for i := 0; i < pl.len(); i++ {
+			entry := pl.at(i)
+			iw.writeUvarint(uint64(entry.setIndex))
+			iw.writeUvarint(uint64(entry.tokenPosition))
+			iw.writeUvarint(uint64(entry.setSize))
+		}
+	})
+	return iw.close()
+}
+
+// ReadSearchIndex reads a search index written by SearchIndex.WriteTo.
+// It returns an error if the format version is not supported, the
+// similarity function is not supported, the checksum does not match, or the
+// posting lists do not match the sets.
+func ReadSearchIndex(r io.Reader) (*SearchIndex, error) {
+	ir := newIndexReader(r)
+	var magic [4]byte
+	ir.read(magic[:])
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	if magic != searchIndexMagic {
+		return nil, errors.New("input is not a search index")
+	}
+	version := ir.readUint32()
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	if version != searchIndexFormatVersion {
+		return nil, fmt.Errorf("search index format version %d is not supported, expecting version %d",
+			version, searchIndexFormatVersion)
+	}
+	similarityFunctionName := ir.readString()
+	similarityThreshold := ir.readFloat64()
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	si, err := newEmptySearchIndex(similarityFunctionName,
+		similarityThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("search index uses similarity function %q with threshold %v: %v",
+			similarityFunctionName, similarityThreshold, err)
+	}
+	// Read the sets.
+	numSets := ir.readLength(math.MaxInt32)
+	si.sets = make([][]int, 0, min(numSets, maxPreallocation))
+	si.removed = make([]bool, 0, min(numSets, maxPreallocation))
+	var flag [1]byte
+	for x := 0; x < numSets && ir.err == nil; x++ {
+		ir.read(flag[:])
+		s := ir.readSet()
+		if flag[0] == 1 {
+			s = nil
+		}
+		si.sets = append(si.sets, s)
+		si.removed = append(si.removed, flag[0] == 1)
+	}
+	// Read the posting lists.
+	numTokens := ir.readLength(math.MaxInt32)
+	for i := 0; i < numTokens && ir.err == nil; i++ {
+		token := int(ir.readVarint())
+		n := ir.readLength(uint64(numSets))
+		postingList := make([]postingListEntry, 0, min(n, maxPreallocation))
+		for j := 0; j < n && ir.err == nil; j++ {
+			var entry postingListEntry
+			entry.setIndex = ir.readLength(uint64(numSets - 1))
+			entry.tokenPosition = ir.readLength(math.MaxInt32)
+			entry.setSize = ir.readLength(math.MaxInt32)
+			postingList = append(postingList, entry)
+		}
+		si.postingLists[token] = postingList
+	}
+	if err := ir.checkChecksum(); err != nil {
+		return nil, err
+	}
+	// Check the entries point to positions in the sets, and the posting
+	// lists are sorted by set size.
+	for _, postingList := range si.postingLists {
+		for j, entry := range postingList {
+			x := entry.setIndex
+			if si.removed[x] || entry.setSize != len(si.sets[x]) ||
+				entry.tokenPosition >= entry.setSize ||
+				(j > 0 && entry.setSize < postingList[j-1].setSize) {
+				return nil, errors.New("invalid posting list entry in binary format")
+			}
+		}
+	}
+	return si, nil
+}
+
+// WriteTo writes the dictionary in a compact versioned binary format to w:
+// the raw tokens in the order of their integer tokens, each with the
+// difference from the previous integer token, followed by a checksum.
+// It implements io.WriterTo.  Use ReadDictionary to read the dictionary
+// back.
+func (dict Dictionary) WriteTo(w io.Writer) (int64, error) {
+	iw := newIndexWriter(w)
+	iw.write(dictionaryMagic[:])
+	iw.writeUint32(dictionaryFormatVersion)
+	iw.writeUvarint(uint64(len(dict)))
+	prev := 0
+	for _, rawToken := range dict.Tokens() {
+		token := dict[rawToken]
+		iw.writeVarint(int64(token - prev))
+		iw.writeString(rawToken)
+		prev = token
+	}
+	return iw.close()
+}
+
+// ReadDictionary reads a dictionary written by Dictionary.WriteTo.
+// It returns an error if the format version is not supported, or the
+// checksum does not match.
+func ReadDictionary(r io.Reader) (Dictionary, error) {
+	ir := newIndexReader(r)
+	var magic [4]byte
+	ir.read(magic[:])
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	if magic != dictionaryMagic {
+		return nil, errors.New("input is not a dictionary")
+	}
+	version := ir.readUint32()
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	if version != dictionaryFormatVersion {
+		return nil, fmt.Errorf("dictionary format version %d is not supported, expecting version %d",
+			version, dictionaryFormatVersion)
+	}
+	n := ir.readLength(math.MaxInt32)
+	dict := make(Dictionary, min(n, maxPreallocation))
+	prev := 0
+	for i := 0; i < n && ir.err == nil; i++ {
+		prev += int(ir.readVarint())
+		rawToken := ir.readString()
+		if _, exists := dict[rawToken]; exists && ir.err == nil {
+			ir.err = errors.New("repeated raw token in binary format")
+		}
+		dict[rawToken] = prev
+	}
+	if err := ir.checkChecksum(); err != nil {
+		return nil, err
+	}
+	return dict, nil
+}
@@ -0,0 +1,148 @@
+package SetSimilaritySearch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestSearchIndexWriteRead(t *testing.T) {
+	sets := randomSets(300, 20, 50, 5)
+	searchIndex, err := NewSearchIndex(sets[:200], "jaccard", 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searchIndex.Add(sets[200])
+	if err := searchIndex.Remove(3); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := searchIndex.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Expecting %d bytes written got %d", buf.Len(), n)
+	}
+	data := buf.Bytes()
+	loaded, err := ReadSearchIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range sets {
+		checkResults(t, loaded.Query(query), searchIndex.Query(query))
+	}
+	// The loaded index can be updated.
+	if x := loaded.Add(sets[201]); x != 201 {
+		t.Errorf("Expecting new set index 201 got %d", x)
+	}
+	if err := loaded.Remove(3); err == nil {
+		t.Error("Expecting removed set to stay removed")
+	}
+
+	// Corrupted data.
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)/2]++
+	if _, err := ReadSearchIndex(bytes.NewReader(corrupted)); err == nil {
+		t.Error("Expecting error reading corrupted data")
+	}
+	// Truncated data.
+	if _, err := ReadSearchIndex(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("Expecting error reading truncated data")
+	}
+	// Unsupported version.
+	unsupported := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(unsupported[4:], searchIndexFormatVersion+1)
+	if _, err := ReadSearchIndex(bytes.NewReader(unsupported)); err == nil {
+		t.Error("Expecting error reading unsupported version")
+	}
+	// Corrupted entries with a valid checksum, with the token position, set
+	// size or set index of an entry changed, or the entries out of order.
+	for i, corrupt := range []func(entries []postingListEntry){
+		func(entries []postingListEntry) { entries[0].tokenPosition = entries[0].setSize },
+		func(entries []postingListEntry) { entries[0].setSize++ },
+		func(entries []postingListEntry) { entries[0].setIndex = 3 },
+		func(entries []postingListEntry) {
+			entries[0], entries[len(entries)-1] = entries[len(entries)-1], entries[0]
+		},
+	} {
+		corruptedIndex, err := ReadSearchIndex(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrupted := false
+		for _, entries := range corruptedIndex.postingLists {
+			if entries[0].setSize < entries[len(entries)-1].setSize {
+				corrupt(entries)
+				corrupted = true
+				break
+			}
+		}
+		if !corrupted {
+			t.Fatal("Expecting a posting list with different set sizes")
+		}
+		var corruptedBuf bytes.Buffer
+		if _, err := corruptedIndex.WriteTo(&corruptedBuf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadSearchIndex(&corruptedBuf); err == nil {
+			t.Errorf("Expecting error reading corrupted entry %d", i)
+		}
+	}
+	// Unsupported similarity function.
+	unsupported = append([]byte(nil), data...)
+	copy(unsupported[9:], "xaccard")
+	if _, err := ReadSearchIndex(bytes.NewReader(unsupported)); err == nil {
+		t.Error("Expecting error reading unsupported similarity function")
+	}
+}
+
+func TestDictionaryWriteRead(t *testing.T) {
+	_, dict := FrequencyOrderTransform([][]string{
+		[]string{"a", "b", "c"},
+		[]string{"a", "b"},
+		[]string{"a", string(bytes.Repeat([]byte{'d'}, 100000))},
+	})
+	var buf bytes.Buffer
+	n, err := dict.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Expecting %d bytes written got %d", buf.Len(), n)
+	}
+	data := buf.Bytes()
+	loaded, err := ReadDictionary(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(dict) {
+		t.Errorf("Expecting %d raw tokens got %d", len(dict), len(loaded))
+	}
+	for rawToken, token := range dict {
+		if loaded[rawToken] != token {
+			t.Errorf("Expecting %.10v's token is %v, got %v", rawToken, token,
+				loaded[rawToken])
+		}
+	}
+	// Corrupted data.
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)/2]++
+	if _, err := ReadDictionary(bytes.NewReader(corrupted)); err == nil {
+		t.Error("Expecting error reading corrupted data")
+	}
+	// Truncated data.
+	if _, err := ReadDictionary(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("Expecting error reading truncated data")
+	}
+	// A search index is not a dictionary.
+	searchIndex, err := NewSearchIndex([][]int{[]int{1}}, "jaccard", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	searchIndex.WriteTo(&buf)
+	if _, err := ReadDictionary(&buf); err == nil {
+		t.Error("Expecting error reading a search index")
+	}
+}
@@ -1,6 +1,9 @@
 package SetSimilaritySearch
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
 
 func min(a, b int) int {
 	if a < b {
@@ -38,31 +41,79 @@ func intersectionSize(s1, s2 []int) int {
 
 type function func([]int, []int) float64
 
+// overlapFunction computes the similarity of two sets from their overlap
+// and sizes.
+type overlapFunction func(int, int, int) float64
+
 // Jaccard computes the Jaccard similarity of two transformed sets.
 func jaccard(s1, s2 []int) float64 {
-	if len(s1) == 0 && len(s2) == 0 {
+	return jaccardOverlap(intersectionSize(s1, s2), len(s1), len(s2))
+}
+
+func jaccardOverlap(overlap, l1, l2 int) float64 {
+	if l1 == 0 && l2 == 0 {
 		return 0.0
 	}
-	intersectionSize := intersectionSize(s1, s2)
-	return float64(intersectionSize) / float64(len(s1)+len(s2)-intersectionSize)
+	return float64(overlap) / float64(l1+l2-overlap)
 }
 
 // Containment computes the Containment of s1 in s2 -- the fraction of s1
 // being found in s2.
 func containment(s1, s2 []int) float64 {
-	if len(s1) == 0 {
+	return containmentOverlap(intersectionSize(s1, s2), len(s1), len(s2))
+}
+
+func containmentOverlap(overlap, l1, l2 int) float64 {
+	if l1 == 0 {
 		return 0.0
 	}
-	intersectionSize := intersectionSize(s1, s2)
-	return float64(intersectionSize) / float64(len(s1))
+	return float64(overlap) / float64(l1)
 }
 
 func cosine(s1, s2 []int) float64 {
-	if len(s1) == 0 && len(s2) == 0 {
+	return cosineOverlap(intersectionSize(s1, s2), len(s1), len(s2))
+}
+
+func cosineOverlap(overlap, l1, l2 int) float64 {
+	if l1 == 0 && l2 == 0 {
 		return 0.0
 	}
-	intersectionSize := intersectionSize(s1, s2)
-	return float64(intersectionSize) / math.Sqrt(float64(len(s1)*len(s2)))
+	return float64(overlap) / math.Sqrt(float64(l1*l2))
+}
+
+// Dice computes the Dice (Sorensen) similarity of two transformed sets.
+func dice(s1, s2 []int) float64 {
+	return diceOverlap(intersectionSize(s1, s2), len(s1), len(s2))
+}
+
+func diceOverlap(overlap, l1, l2 int) float64 {
+	if l1 == 0 && l2 == 0 {
+		return 0.0
+	}
+	return 2.0 * float64(overlap) / float64(l1+l2)
+}
+
+// overlapCoefficient computes the overlap coefficient (Szymkiewicz-Simpson)
+// of two transformed sets.
+func overlapCoefficient(s1, s2 []int) float64 {
+	return overlapCoefficientOverlap(intersectionSize(s1, s2), len(s1), len(s2))
+}
+
+func overlapCoefficientOverlap(overlap, l1, l2 int) float64 {
+	if l1 == 0 || l2 == 0 {
+		return 0.0
+	}
+	return float64(overlap) / float64(min(l1, l2))
+}
+
+// intersectionSizeSimilarity uses the number of overlaps of two transformed
+// sets as their similarity.
+func intersectionSizeSimilarity(s1, s2 []int) float64 {
+	return intersectionSizeOverlap(intersectionSize(s1, s2), len(s1), len(s2))
+}
+
+func intersectionSizeOverlap(overlap, l1, l2 int) float64 {
+	return float64(overlap)
 }
 
 type overlapThresholdFunction func(int, float64) int
@@ -76,11 +127,44 @@ func jaccardOverlapThresholdFunc(x int, t float64) int {
 var jaccardOverlapIndexThresholdFunc = jaccardOverlapThresholdFunc
 
 func cosineOverlapThresholdFunc(x int, t float64) int {
-	return int(math.Sqrt(float64(x)) * t)
+	return max(1, int(math.Sqrt(float64(x))*t))
 }
 
 var cosineOverlapIndexThresholdFunc = cosineOverlapThresholdFunc
 
+// The overlap o with a set of size y >= o meets the Dice threshold t only if
+// 2*o/(x+o) >= t, that is, o >= x*t/(2-t).
+func diceOverlapThresholdFunc(x int, t float64) int {
+	return max(1, int(float64(x)*t/(2-t)))
+}
+
+var diceOverlapIndexThresholdFunc = diceOverlapThresholdFunc
+
+// A set of size y < x meets the overlap coefficient threshold with a single
+// overlap when y is small enough, so the whole set is the prefix.
+func overlapCoefficientOverlapThresholdFunc(x int, t float64) int {
+	return 1
+}
+
+var overlapCoefficientOverlapIndexThresholdFunc = overlapCoefficientOverlapThresholdFunc
+
+// intersectionSizeCeil rounds up an intersection size threshold, which is
+// not bounded by 1 like the other similarity thresholds.
+func intersectionSizeCeil(t float64) int {
+	if t >= math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return overlapCeil(t)
+}
+
+// A set smaller than the intersection size threshold has no results, so the
+// overlap threshold is capped at the set size to keep the prefix non-empty.
+func intersectionSizeOverlapThresholdFunc(x int, t float64) int {
+	return max(1, min(x, intersectionSizeCeil(t)))
+}
+
+var intersectionSizeOverlapIndexThresholdFunc = intersectionSizeOverlapThresholdFunc
+
 // This is used for query only.
 func containmentOverlapThresholdFunc(x int, t float64) int {
 	return max(1, int(float64(x)*t))
@@ -90,49 +174,324 @@ func containmentOverlapIndexThresholdFunc(x int, t float64) int {
 	return 1
 }
 
-type positionFilter func([]int, []int, int, int, float64) bool
+// pairOverlapThresholdFunction takes the sizes of two sets and a similarity
+// threshold, and returns the minimum overlap for the sets to meet the
+// threshold.
+type pairOverlapThresholdFunction func(int, int, float64) int
+
+// overlapCeil rounds up an overlap threshold, allowing for floating point
+// errors so the threshold is never more than the exact one.
+func overlapCeil(x float64) int {
+	return int(math.Ceil(x - 1e-9))
+}
+
+func jaccardPairOverlapThresholdFunc(l1, l2 int, t float64) int {
+	return overlapCeil(t / (1 + t) * float64(l1+l2))
+}
+
+func containmentPairOverlapThresholdFunc(l1, l2 int, t float64) int {
+	return overlapCeil(t * float64(l1))
+}
+
+func cosinePairOverlapThresholdFunc(l1, l2 int, t float64) int {
+	return overlapCeil(t * math.Sqrt(float64(l1)*float64(l2)))
+}
+
+func dicePairOverlapThresholdFunc(l1, l2 int, t float64) int {
+	return overlapCeil(t / 2 * float64(l1+l2))
+}
+
+func overlapCoefficientPairOverlapThresholdFunc(l1, l2 int, t float64) int {
+	return overlapCeil(t * float64(min(l1, l2)))
+}
+
+func intersectionSizePairOverlapThresholdFunc(l1, l2 int, t float64) int {
+	return intersectionSizeCeil(t)
+}
+
+// sizeBoundsFunction takes the size of a query set and a similarity
+// threshold, and returns the minimum and maximum sizes of the sets that can
+// meet the threshold with the query set.
+type sizeBoundsFunction func(int, float64) (int, int)
+
+// sizeFloor rounds down a set size bound, allowing for floating point errors
+// so the bound is never less than the exact one.
+func sizeFloor(x float64) int {
+	if x >= math.MaxInt32 {
+		return math.MaxInt
+	}
+	return int(math.Floor(x + 1e-9))
+}
 
-func jaccardPositionFilter(s1, s2 []int, p1, p2 int, t float64) bool {
-	l1, l2 := len(s1), len(s2)
+// A set of size y meets the Jaccard threshold t with a set of size x only if
+// t*x <= y <= x/t, as the overlap is at most min(x, y).
+func jaccardSizeBounds(x int, t float64) (int, int) {
+	if t == 0 {
+		return 0, math.MaxInt
+	}
+	return overlapCeil(t * float64(x)), sizeFloor(float64(x) / t)
+}
+
+// A set of size y meets the Dice threshold t with a set of size x only if
+// x*t/(2-t) <= y <= x*(2-t)/t.
+func diceSizeBounds(x int, t float64) (int, int) {
+	if t == 0 {
+		return 0, math.MaxInt
+	}
+	return overlapCeil(float64(x) * t / (2 - t)), sizeFloor(float64(x) * (2 - t) / t)
+}
+
+// The containment of x in y has no upper bound on y.
+func containmentSizeBounds(x int, t float64) (int, int) {
+	return overlapCeil(t * float64(x)), math.MaxInt
+}
+
+func cosineSizeBounds(x int, t float64) (int, int) {
+	if t == 0 {
+		return 0, math.MaxInt
+	}
+	return overlapCeil(t * t * float64(x)), sizeFloor(float64(x) / (t * t))
+}
+
+// The overlap coefficient has no bounds on y, as a set contained in the
+// other one has the maximum similarity.
+func overlapCoefficientSizeBounds(x int, t float64) (int, int) {
+	return 0, math.MaxInt
+}
+
+func intersectionSizeSizeBounds(x int, t float64) (int, int) {
+	return intersectionSizeCeil(t), math.MaxInt
+}
+
+// positionFilter takes the sizes of two sets and the positions of their
+// first matching token, and returns whether the sets can still meet the
+// similarity threshold.
+type positionFilter func(int, int, int, int, float64) bool
+
+func jaccardPositionFilter(l1, l2, p1, p2 int, t float64) bool {
 	return float64(min(l1-p1, l2-p2))/float64(max(l1, l2)) >= t
 }
 
-func containmentPositionFilter(s1, s2 []int, p1, p2 int, t float64) bool {
-	l1, l2 := len(s1), len(s2)
+func containmentPositionFilter(l1, l2, p1, p2 int, t float64) bool {
 	return float64(min(l1-p1, l2-p2))/float64(l1) >= t
 }
 
-func cosinePositionFilter(s1, s2 []int, p1, p2 int, t float64) bool {
-	l1, l2 := len(s1), len(s2)
+func dicePositionFilter(l1, l2, p1, p2 int, t float64) bool {
+	return 2.0*float64(min(l1-p1, l2-p2))/float64(l1+l2) >= t
+}
+
+func overlapCoefficientPositionFilter(l1, l2, p1, p2 int, t float64) bool {
+	return float64(min(l1-p1, l2-p2))/float64(min(l1, l2)) >= t
+}
+
+func intersectionSizePositionFilter(l1, l2, p1, p2 int, t float64) bool {
+	return float64(min(l1-p1, l2-p2)) >= t
+}
+
+func cosinePositionFilter(l1, l2, p1, p2 int, t float64) bool {
 	return float64(min(l1-p1, l2-p2))/math.Sqrt(float64(max(l1, l2))) >= t
 }
 
+// checkThreshold returns an error if the similarity threshold is not in the
+// range of the similarity function.
+func checkThreshold(similarityFunctionName string, similarityThreshold float64) error {
+	maxThreshold := maxThresholds[similarityFunctionName]
+	if similarityThreshold < 0 || similarityThreshold > maxThreshold {
+		return fmt.Errorf("input similarityThreshold must be in the range [0, %v]",
+			maxThreshold)
+	}
+	return nil
+}
+
 var similarityFuncs = map[string]function{
-	"jaccard":     jaccard,
-	"containment": containment,
-	"cosine":      cosine,
+	"jaccard":           jaccard,
+	"containment":       containment,
+	"cosine":            cosine,
+	"dice":              dice,
+	"overlap":           overlapCoefficient,
+	"intersection_size": intersectionSizeSimilarity,
+}
+
+var overlapFuncs = map[string]overlapFunction{
+	"jaccard":           jaccardOverlap,
+	"containment":       containmentOverlap,
+	"cosine":            cosineOverlap,
+	"dice":              diceOverlap,
+	"overlap":           overlapCoefficientOverlap,
+	"intersection_size": intersectionSizeOverlap,
 }
 
 var overlapThresholdFuncs = map[string]overlapThresholdFunction{
-	"jaccard":     jaccardOverlapThresholdFunc,
-	"containment": containmentOverlapThresholdFunc,
-	"cosine":      cosineOverlapThresholdFunc,
+	"jaccard":           jaccardOverlapThresholdFunc,
+	"containment":       containmentOverlapThresholdFunc,
+	"cosine":            cosineOverlapThresholdFunc,
+	"dice":              diceOverlapThresholdFunc,
+	"overlap":           overlapCoefficientOverlapThresholdFunc,
+	"intersection_size": intersectionSizeOverlapThresholdFunc,
 }
 
 var overlapIndexThresholdFuncs = map[string]overlapThresholdFunction{
-	"jaccard":     jaccardOverlapIndexThresholdFunc,
-	"containment": containmentOverlapIndexThresholdFunc,
-	"cosine":      cosineOverlapIndexThresholdFunc,
+	"jaccard":           jaccardOverlapIndexThresholdFunc,
+	"containment":       containmentOverlapIndexThresholdFunc,
+	"cosine":            cosineOverlapIndexThresholdFunc,
+	"dice":              diceOverlapIndexThresholdFunc,
+	"overlap":           overlapCoefficientOverlapIndexThresholdFunc,
+	"intersection_size": intersectionSizeOverlapIndexThresholdFunc,
+}
+
+var pairOverlapThresholdFuncs = map[string]pairOverlapThresholdFunction{
+	"jaccard":           jaccardPairOverlapThresholdFunc,
+	"containment":       containmentPairOverlapThresholdFunc,
+	"cosine":            cosinePairOverlapThresholdFunc,
+	"dice":              dicePairOverlapThresholdFunc,
+	"overlap":           overlapCoefficientPairOverlapThresholdFunc,
+	"intersection_size": intersectionSizePairOverlapThresholdFunc,
 }
 
 var positionFilterFuncs = map[string]positionFilter{
-	"jaccard":     jaccardPositionFilter,
-	"containment": containmentPositionFilter,
-	"cosine":      cosinePositionFilter,
+	"jaccard":           jaccardPositionFilter,
+	"containment":       containmentPositionFilter,
+	"cosine":            cosinePositionFilter,
+	"dice":              dicePositionFilter,
+	"overlap":           overlapCoefficientPositionFilter,
+	"intersection_size": intersectionSizePositionFilter,
+}
+
+var sizeBoundsFuncs = map[string]sizeBoundsFunction{
+	"jaccard":           jaccardSizeBounds,
+	"containment":       containmentSizeBounds,
+	"cosine":            cosineSizeBounds,
+	"dice":              diceSizeBounds,
+	"overlap":           overlapCoefficientSizeBounds,
+	"intersection_size": intersectionSizeSizeBounds,
+}
+
+// maxThresholds is the maximum similarity threshold of each function.
+var maxThresholds = map[string]float64{
+	"jaccard":           1,
+	"containment":       1,
+	"cosine":            1,
+	"dice":              1,
+	"overlap":           1,
+	"intersection_size": math.Inf(1),
 }
 
 var symmetricSimilarityFuncs = map[string]bool{
-	"jaccard":     true,
-	"containment": false,
-	"cosine":      true,
+	"jaccard":           true,
+	"containment":       false,
+	"cosine":            true,
+	"dice":              true,
+	"overlap":           true,
+	"intersection_size": true,
+}
+
+// SimilarityFunction is a user-defined similarity function of two sets that
+// is computed from their overlap (intersection size) and sizes, as the prefix
+// filter is only correct for such functions.  The sizes and overlaps are of
+// transformed sets, and the positions are 0-based token positions in them.
+//
+// The overlap thresholds must never be more than the exact minimum overlap,
+// otherwise some results are missed; lower thresholds are correct but use
+// longer prefixes.  Similarly, PositionFilter must return true whenever the
+// sets can meet the threshold.
+type SimilarityFunction interface {
+	// Similarity computes the similarity of two sets given their overlap
+	// and sizes.
+	Similarity(overlap, size1, size2 int) float64
+	// OverlapThreshold returns the minimum overlap of a query set of the
+	// given size with any set to meet the threshold, which decides the
+	// prefix of the query set.
+	OverlapThreshold(size int, threshold float64) int
+	// IndexOverlapThreshold returns the minimum overlap of an indexed set of
+	// the given size with any query set to meet the threshold, which decides
+	// the prefix of the indexed set.  For a symmetric function it is usually
+	// the same as OverlapThreshold.
+	IndexOverlapThreshold(size int, threshold float64) int
+	// PositionFilter takes the sizes of a query set and an indexed set, and
+	// the positions of their first matching token, and returns whether the
+	// sets can still meet the threshold.
+	PositionFilter(size1, size2, position1, position2 int,
+		threshold float64) bool
+	// Symmetric returns whether the similarity is the same when the two sets
+	// are swapped.
+	Symmetric() bool
+}
+
+// PairOverlapThresholder is implemented by a SimilarityFunction that can
+// bound the overlap of two sets given both sizes.  It is optional, and prunes
+// more candidates before they are verified.
+type PairOverlapThresholder interface {
+	// PairOverlapThreshold returns the minimum overlap of a query set and an
+	// indexed set of the given sizes to meet the threshold.
+	PairOverlapThreshold(size1, size2 int, threshold float64) int
+}
+
+// SizeBounder is implemented by a SimilarityFunction that can bound the
+// sizes of the sets meeting the threshold with a query set.  It is optional,
+// and skips the sets outside the bounds in the posting lists.
+type SizeBounder interface {
+	// SizeBounds returns the minimum and maximum sizes of the indexed sets
+	// that can meet the threshold with a query set of the given size.
+	SizeBounds(size int, threshold float64) (lower, upper int)
+}
+
+// MaxThresholder is implemented by a SimilarityFunction whose thresholds are
+// not bounded by 1, such as a count of shared tokens.  It is optional, and
+// the thresholds are in the range [0, 1] without it.
+type MaxThresholder interface {
+	// MaxThreshold returns the maximum similarity threshold, which may be
+	// math.Inf(1).
+	MaxThreshold() float64
+}
+
+// RegisterSimilarity makes a user-defined similarity function available by
+// the given name to AllPairs, NewSearchIndex and the other functions taking
+// a similarity function name, with thresholds in the range [0, 1], or
+// [0, MaxThreshold()] if it implements MaxThresholder.
+// It should be called during initialization, such as in an init function,
+// as it is not safe to call concurrently with the other functions.
+// The implementation may also implement PairOverlapThresholder and
+// SizeBounder for more filtering.
+// A saved search index records only the name, so the name should identify
+// the implementation including any parameters, such as "tversky_0.8_0.2" for
+// a Tversky index; an index loaded with a different implementation under
+// the same name misses results without an error.
+// It panics if impl is nil or the name is already registered.
+func RegisterSimilarity(name string, impl SimilarityFunction) {
+	if impl == nil {
+		panic("SetSimilaritySearch: RegisterSimilarity impl is nil")
+	}
+	if _, exists := similarityFuncs[name]; exists {
+		panic("SetSimilaritySearch: RegisterSimilarity called twice for " + name)
+	}
+	similarityFuncs[name] = func(s1, s2 []int) float64 {
+		return impl.Similarity(intersectionSize(s1, s2), len(s1), len(s2))
+	}
+	overlapFuncs[name] = impl.Similarity
+	// The prefixes must be non-empty and no longer than the sets.
+	overlapThresholdFuncs[name] = func(x int, t float64) int {
+		return max(1, min(x, impl.OverlapThreshold(x, t)))
+	}
+	overlapIndexThresholdFuncs[name] = func(x int, t float64) int {
+		return max(1, min(x, impl.IndexOverlapThreshold(x, t)))
+	}
+	positionFilterFuncs[name] = impl.PositionFilter
+	// Without a bound on the overlap of a pair, every candidate is verified.
+	pairOverlapThresholdFuncs[name] = func(int, int, float64) int {
+		return 1
+	}
+	if f, ok := impl.(PairOverlapThresholder); ok {
+		pairOverlapThresholdFuncs[name] = f.PairOverlapThreshold
+	}
+	sizeBoundsFuncs[name] = func(int, float64) (int, int) {
+		return 0, math.MaxInt
+	}
+	if f, ok := impl.(SizeBounder); ok {
+		sizeBoundsFuncs[name] = f.SizeBounds
+	}
+	maxThresholds[name] = 1
+	if f, ok := impl.(MaxThresholder); ok {
+		maxThresholds[name] = f.MaxThreshold()
+	}
+	symmetricSimilarityFuncs[name] = impl.Symmetric()
 }
@@ -0,0 +1,127 @@
+package SetSimilaritySearch
+
+import (
+	"math"
+	"testing"
+)
+
+// testDice is the Dice similarity as a user-defined similarity function.
+type testDice struct{}
+
+func (testDice) Similarity(overlap, size1, size2 int) float64 {
+	return diceOverlap(overlap, size1, size2)
+}
+
+func (testDice) OverlapThreshold(size int, threshold float64) int {
+	return diceOverlapThresholdFunc(size, threshold)
+}
+
+func (testDice) IndexOverlapThreshold(size int, threshold float64) int {
+	return diceOverlapIndexThresholdFunc(size, threshold)
+}
+
+func (testDice) PositionFilter(size1, size2, position1, position2 int,
+	threshold float64) bool {
+	return dicePositionFilter(size1, size2, position1, position2, threshold)
+}
+
+func (testDice) Symmetric() bool { return true }
+
+// testCount is the number of shared tokens as a user-defined similarity
+// function, with thresholds above 1.
+type testCount struct{}
+
+func (testCount) Similarity(overlap, size1, size2 int) float64 {
+	return float64(overlap)
+}
+
+func (testCount) OverlapThreshold(size int, threshold float64) int {
+	return intersectionSizeCeil(threshold)
+}
+
+func (testCount) IndexOverlapThreshold(size int, threshold float64) int {
+	return intersectionSizeCeil(threshold)
+}
+
+func (testCount) PositionFilter(size1, size2, position1, position2 int,
+	threshold float64) bool {
+	return float64(min(size1-position1, size2-position2)) >= threshold
+}
+
+func (testCount) Symmetric() bool { return true }
+
+func (testCount) MaxThreshold() float64 { return math.Inf(1) }
+
+func init() {
+	RegisterSimilarity("test_dice", testDice{})
+	RegisterSimilarity("test_count", testCount{})
+}
+
+func TestSizeBounds(t *testing.T) {
+	for name, sizeBounds := range sizeBoundsFuncs {
+		overlapFunc := overlapFuncs[name]
+		for _, threshold := range []float64{0, 0.1, 0.5, 0.7, 0.9, 1} {
+			for x := 1; x <= 30; x++ {
+				lower, upper := sizeBounds(x, threshold)
+				for y := 1; y <= 100; y++ {
+					// The largest similarity is when the overlap is the
+					// smaller size.
+					sim := overlapFunc(min(x, y), x, y)
+					if sim >= threshold && (y < lower || y > upper) {
+						t.Errorf("%s: size %d with query size %d and threshold %v is outside bounds [%d, %d]",
+							name, y, x, threshold, lower, upper)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRegisterSimilarity(t *testing.T) {
+	sets := randomSets(300, 20, 50, 21)
+	pairs, err := AllPairs(sets, "dice", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	correctPairs := collectPairs(pairs)
+	pairs, err = AllPairs(sets, "test_dice", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := collectPairs(pairs)
+	for p := range found {
+		if !correctPairs[p] {
+			t.Errorf("The pair %v is not correct", p)
+		}
+	}
+	if len(found) != len(correctPairs) {
+		t.Errorf("Expecting %d pairs but found %d", len(correctPairs),
+			len(found))
+	}
+	searchIndex, err := NewSearchIndex(sets, "test_dice", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range sets[:50] {
+		checkResults(t, searchIndex.Query(query),
+			bruteForceQuery(sets, nil, query, dice, 0.5))
+	}
+	if _, err := NewSearchIndex(sets, "test_dice", 1.5); err == nil {
+		t.Error("Expecting an error for a threshold above 1")
+	}
+	// A registered count with a threshold above 1.
+	searchIndex, err = NewSearchIndex(sets, "test_count", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range sets[:50] {
+		checkResults(t, searchIndex.Query(query),
+			bruteForceQuery(sets, nil, query, intersectionSizeSimilarity, 4))
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expecting a panic registering a name twice")
+		}
+	}()
+	RegisterSimilarity("jaccard", testDice{})
+}
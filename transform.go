@@ -11,52 +11,237 @@ type Dictionary map[string]int
 // This step speeds up subsequent prefix filtering and similarity
 // computation.  See Section 4.3.2 in the paper "A Primitive Operator for
 // Similarity Joins in Data Cleaning" by Chaudhuri et al..
+// Raw tokens with the same frequency are ordered by the raw tokens, so the
+// transformation is deterministic.  A raw token repeated in a set is only
+// kept once, use MultisetFrequencyOrderTransform if the repeats matter.
 func FrequencyOrderTransform(rawSets [][]string) (sets [][]int,
 	dict Dictionary) {
-	// Count token frequencies.
-	counts := make(map[string]int)
+	sets, d := FrequencyOrderTransformOf(rawSets)
+	return sets, Dictionary(d)
+}
+
+func lessString(a, b string) bool {
+	return a < b
+}
+
+// FrequencyOrderTransformFunc is the same as FrequencyOrderTransform, but
+// orders the raw tokens with the same frequency using less, which reports
+// whether raw token a is before raw token b.  The raw tokens that less does
+// not order are ordered by the raw tokens.
+func FrequencyOrderTransformFunc(rawSets [][]string,
+	less func(a, b string) bool) (sets [][]int, dict Dictionary) {
+	sets, d := FrequencyOrderTransformFuncOf(rawSets, func(a, b string) bool {
+		if less(a, b) || less(b, a) {
+			return less(a, b)
+		}
+		return a < b
+	})
+	return sets, Dictionary(d)
+}
+
+// countFrequencies adds the number of sets containing each raw token to
+// counts, counting a repeated raw token once per set.
+func countFrequencies[T comparable](rawSets [][]T, counts map[T]int) {
 	for _, rawSet := range rawSets {
+		seen := make(map[T]bool, len(rawSet))
 		for _, rawToken := range rawSet {
-			if _, exists := counts[rawToken]; !exists {
-				counts[rawToken] = 0
+			if seen[rawToken] {
+				continue
 			}
+			seen[rawToken] = true
 			counts[rawToken]++
 		}
 	}
-	// Create token order based on global frequency.
-	type entry struct {
-		rawToken string
-		freq     int
-	}
-	entries := make([]entry, 0, len(counts))
-	for rawToken, freq := range counts {
-		entries = append(entries, entry{rawToken, freq})
+}
+
+// frequencyOrder returns the raw tokens in counts in the order of their
+// frequencies, ordering the ones with the same frequency using less, which
+// must order any two different raw tokens so the order does not depend on
+// the map iteration.
+func frequencyOrder[T comparable](counts map[T]int,
+	less func(a, b T) bool) []T {
+	rawTokens := make([]T, 0, len(counts))
+	for rawToken := range counts {
+		rawTokens = append(rawTokens, rawToken)
 	}
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].freq < entries[j].freq
+	sort.Slice(rawTokens, func(i, j int) bool {
+		a, b := rawTokens[i], rawTokens[j]
+		if counts[a] != counts[b] {
+			return counts[a] < counts[b]
+		}
+		return less(a, b)
 	})
-	dict = make(Dictionary)
-	for i, entry := range entries {
-		dict[entry.rawToken] = i
+	return rawTokens
+}
+
+// NewDictionary creates a dictionary from the raw tokens in the global
+// order, such as the ones returned by Dictionary.Tokens, so an ordering can
+// be persisted and reused to transform sets the same way.
+// It returns an error if a raw token is repeated.
+func NewDictionary(rawTokens []string) (Dictionary, error) {
+	dict, err := NewDictionaryOf(rawTokens)
+	return Dictionary(dict), err
+}
+
+// Tokens returns the raw tokens of the dictionary in the order of their
+// integer tokens.
+func (dict Dictionary) Tokens() []string {
+	return DictionaryOf[string](dict).Tokens()
+}
+
+// Transform takes a set of raw tokens and returns a set of integer tokens based
+// on the global frequency order.
+func (dict Dictionary) Transform(rawSet []string) (set []int) {
+	return DictionaryOf[string](dict).Transform(rawSet)
+}
+
+// TransformKeepUnknown is the same as Transform, but instead of dropping the
+// raw tokens not in the dictionary, it transforms them to new integer tokens
+// after all tokens in the dictionary, so the size of the transformed set and
+// its similarities with the indexed sets stay correct.  It also returns the
+// unknown raw tokens in the order they first appear in the raw set.
+// The dictionary's integer tokens must be 0 to len(dict)-1, as created by
+// FrequencyOrderTransform, and the new integer tokens start at len(dict)
+// for every call, so they are only valid for querying indexed sets, not for
+// comparing two transformed query sets.
+func (dict Dictionary) TransformKeepUnknown(rawSet []string) (set []int,
+	unknown []string) {
+	set = make([]int, 0, len(rawSet))
+	newTokens := make(map[string]int)
+	for _, rawToken := range rawSet {
+		if token, exists := dict[rawToken]; exists {
+			set = append(set, token)
+			continue
+		}
+		if _, exists := newTokens[rawToken]; !exists {
+			newTokens[rawToken] = len(dict) + len(unknown)
+			unknown = append(unknown, rawToken)
+		}
+		set = append(set, newTokens[rawToken])
 	}
-	// Convert raw tokens into integer tokens.
-	sets = make([][]int, len(rawSets))
+	return sortedUnique(set), unknown
+}
+
+// ReverseDictionary maps integer tokens back to raw tokens.
+type ReverseDictionary map[int]string
+
+// Reverse returns the reverse of the dictionary, for decoding transformed
+// sets back to raw tokens.
+func (dict Dictionary) Reverse() ReverseDictionary {
+	rdict := make(ReverseDictionary, len(dict))
+	for rawToken, token := range dict {
+		rdict[token] = rawToken
+	}
+	return rdict
+}
+
+// Lookup returns the raw token of an integer token, and whether the integer
+// token is in the dictionary.
+func (rdict ReverseDictionary) Lookup(token int) (string, bool) {
+	rawToken, exists := rdict[token]
+	return rawToken, exists
+}
+
+// Decode takes a transformed set and returns its raw tokens in the same
+// order, dropping the integer tokens not in the dictionary, such as the new
+// ones given by Dictionary.TransformKeepUnknown.
+func (rdict ReverseDictionary) Decode(set []int) (rawSet []string) {
+	rawSet = make([]string, 0, len(set))
+	for _, token := range set {
+		if rawToken, exists := rdict[token]; exists {
+			rawSet = append(rawSet, rawToken)
+		}
+	}
+	return rawSet
+}
+
+// sortedUnique sorts a set of integer tokens and removes the repeated ones
+// in place.
+func sortedUnique(set []int) []int {
+	sort.Ints(set)
+	n := 0
+	for i, token := range set {
+		if i == 0 || token != set[n-1] {
+			set[n] = token
+			n++
+		}
+	}
+	return set[:n]
+}
+
+// MultisetToken is an occurrence of a raw token in a multiset, numbered from
+// 1 for the first occurrence of the raw token in the multiset.
+type MultisetToken struct {
+	Token      string
+	Occurrence int
+}
+
+// multisetTokens returns the occurrences of the raw tokens in a multiset.
+func multisetTokens(rawSet []string) []MultisetToken {
+	counts := make(map[string]int)
+	tokens := make([]MultisetToken, len(rawSet))
+	for i, rawToken := range rawSet {
+		counts[rawToken]++
+		tokens[i] = MultisetToken{rawToken, counts[rawToken]}
+	}
+	return tokens
+}
+
+func lessMultisetToken(a, b MultisetToken) bool {
+	if a.Token != b.Token {
+		return a.Token < b.Token
+	}
+	return a.Occurrence < b.Occurrence
+}
+
+// MultisetDictionary maps raw token occurrences to integer tokens in the
+// global order.
+type MultisetDictionary map[MultisetToken]int
+
+// MultisetFrequencyOrderTransform is the same as FrequencyOrderTransform, but
+// transforms string multisets, in which raw tokens may be repeated.  Each
+// occurrence of a raw token in a multiset is transformed to a different
+// integer token, so the first "a" and the second "a" of a multiset are
+// different tokens, shared with the multisets having at least as many "a".
+// The intersection size of two transformed multisets is then the sum of the
+// minimum counts of their raw tokens, so "jaccard", "containment", "dice",
+// "overlap" and "intersection_size" are the multiset similarities; "cosine"
+// is not the cosine similarity of the token count vectors.
+// Token occurrences with the same frequency are ordered by the raw tokens
+// and then the occurrences.
+func MultisetFrequencyOrderTransform(rawSets [][]string) (sets [][]int,
+	dict MultisetDictionary) {
+	// Count token occurrence frequencies.  The occurrences in a multiset are
+	// different, so each is counted.
+	tokens := make([][]MultisetToken, len(rawSets))
 	for i, rawSet := range rawSets {
-		sets[i] = make([]int, len(rawSet))
-		for j, rawToken := range rawSet {
-			sets[i][j] = dict[rawToken]
+		tokens[i] = multisetTokens(rawSet)
+	}
+	counts := make(map[MultisetToken]int)
+	countFrequencies(tokens, counts)
+	// Create token order based on global frequency.
+	dict = make(MultisetDictionary)
+	for i, token := range frequencyOrder(counts, lessMultisetToken) {
+		dict[token] = i
+	}
+	// Convert token occurrences into integer tokens.
+	sets = make([][]int, len(rawSets))
+	for i := range tokens {
+		sets[i] = make([]int, len(tokens[i]))
+		for j, token := range tokens[i] {
+			sets[i][j] = dict[token]
 		}
 		sort.Ints(sets[i])
 	}
 	return sets, dict
 }
 
-// Transform takes a set of raw tokens and returns a set of integer tokens based
-// on the global frequency order.
-func (dict Dictionary) Transform(rawSet []string) (set []int) {
+// Transform takes a multiset of raw tokens and returns a set of integer
+// tokens based on the global frequency order.
+func (dict MultisetDictionary) Transform(rawSet []string) (set []int) {
 	set = make([]int, 0, len(rawSet))
-	for _, rawToken := range rawSet {
-		if token, exists := dict[rawToken]; exists {
+	for _, token := range multisetTokens(rawSet) {
+		if token, exists := dict[token]; exists {
 			set = append(set, token)
 		}
 	}
@@ -0,0 +1,98 @@
+package SetSimilaritySearch
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ordered is the raw token types with an order, which is used to order the
+// raw tokens with the same frequency.  It is the same as cmp.Ordered, which
+// needs Go 1.21.
+type ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 |
+		~string
+}
+
+// DictionaryOf maps raw tokens of any comparable type, such as hashes or
+// IDs, to integer tokens in the global order.
+type DictionaryOf[T comparable] map[T]int
+
+// FrequencyOrderTransformOf is the same as FrequencyOrderTransform, but
+// transforms sets of raw tokens of any ordered type directly, without
+// converting them to strings.  Raw tokens with the same frequency are
+// ordered by the raw tokens, so the transformation is deterministic and
+// gives the same integer tokens as FrequencyOrderTransform for strings.
+// The raw tokens must be of an integer, float or string type; use
+// FrequencyOrderTransformFuncOf for other comparable types, such as structs
+// or arrays.
+func FrequencyOrderTransformOf[T ordered](rawSets [][]T) (sets [][]int,
+	dict DictionaryOf[T]) {
+	return FrequencyOrderTransformFuncOf(rawSets, func(a, b T) bool {
+		return a < b
+	})
+}
+
+// FrequencyOrderTransformFuncOf is the same as FrequencyOrderTransformOf,
+// but transforms sets of raw tokens of any comparable type, ordering the
+// ones with the same frequency using less, which reports whether raw token
+// a is before raw token b.  For the transformation to be deterministic, less
+// must order any two different raw tokens.
+func FrequencyOrderTransformFuncOf[T comparable](rawSets [][]T,
+	less func(a, b T) bool) (sets [][]int, dict DictionaryOf[T]) {
+	// Count token frequencies, and create token order based on them.
+	counts := make(map[T]int)
+	countFrequencies(rawSets, counts)
+	dict = make(DictionaryOf[T], len(counts))
+	for i, rawToken := range frequencyOrder(counts, less) {
+		dict[rawToken] = i
+	}
+	// Convert raw tokens into integer tokens.
+	sets = make([][]int, len(rawSets))
+	for i, rawSet := range rawSets {
+		sets[i] = dict.Transform(rawSet)
+	}
+	return sets, dict
+}
+
+// NewDictionaryOf creates a dictionary from the raw tokens in the global
+// order, such as the ones returned by DictionaryOf.Tokens, so an ordering can
+// be persisted and reused to transform sets the same way.
+// It returns an error if a raw token is repeated.
+func NewDictionaryOf[T comparable](rawTokens []T) (DictionaryOf[T], error) {
+	dict := make(DictionaryOf[T], len(rawTokens))
+	for i, rawToken := range rawTokens {
+		if _, exists := dict[rawToken]; exists {
+			return nil, fmt.Errorf("input raw token %#v is repeated", rawToken)
+		}
+		dict[rawToken] = i
+	}
+	return dict, nil
+}
+
+// Tokens returns the raw tokens of the dictionary in the order of their
+// integer tokens.
+func (dict DictionaryOf[T]) Tokens() []T {
+	rawTokens := make([]T, 0, len(dict))
+	for rawToken := range dict {
+		rawTokens = append(rawTokens, rawToken)
+	}
+	sort.Slice(rawTokens, func(i, j int) bool {
+		return dict[rawTokens[i]] < dict[rawTokens[j]]
+	})
+	return rawTokens
+}
+
+// Transform takes a set of raw tokens and returns a set of integer tokens based
+// on the global frequency order, dropping the raw tokens not in the
+// dictionary.
+func (dict DictionaryOf[T]) Transform(rawSet []T) (set []int) {
+	set = make([]int, 0, len(rawSet))
+	for _, rawToken := range rawSet {
+		if token, exists := dict[rawToken]; exists {
+			set = append(set, token)
+		}
+	}
+	return sortedUnique(set)
+}
@@ -0,0 +1,106 @@
+package SetSimilaritySearch
+
+import "testing"
+
+func TestFrequencyOrderTransformOf(t *testing.T) {
+	rawSets := [][]uint64{
+		[]uint64{10},
+		[]uint64{10, 20},
+		[]uint64{10, 20, 30},
+		[]uint64{10, 20, 30, 40},
+		[]uint64{10, 20, 30, 40, 50, 50},
+	}
+	correctDict := map[uint64]int{
+		10: 4,
+		20: 3,
+		30: 2,
+		40: 1,
+		50: 0,
+	}
+	sets, dict := FrequencyOrderTransformOf(rawSets)
+	for rawToken, token := range correctDict {
+		if dict[rawToken] != token {
+			t.Errorf("Expect %v's token is %v, got %v", rawToken, token,
+				dict[rawToken])
+		}
+	}
+	// The sets are the same as transforming the raw tokens as strings.
+	_, stringDict := FrequencyOrderTransform([][]string{
+		[]string{"a"},
+		[]string{"a", "b"},
+		[]string{"a", "b", "c"},
+		[]string{"a", "b", "c", "d"},
+		[]string{"a", "b", "c", "d", "e", "e"},
+	})
+	correctSet := stringDict.Transform([]string{"a", "b", "c", "d", "e"})
+	if len(sets[4]) != len(correctSet) {
+		t.Fatalf("Expect transformed set %v got %v", correctSet, sets[4])
+	}
+	for i := range correctSet {
+		if sets[4][i] != correctSet[i] {
+			t.Errorf("Expect transformed set %v got %v", correctSet, sets[4])
+		}
+	}
+	// Tokens with the same frequency are ordered by the raw tokens, whatever
+	// the order of the sets.
+	for _, int64Sets := range [][][]int64{
+		[][]int64{[]int64{7, 3}, []int64{5, 3}},
+		[][]int64{[]int64{5, 3}, []int64{3, 7}},
+	} {
+		_, int64Dict := FrequencyOrderTransformOf(int64Sets)
+		if int64Dict[5] != 0 || int64Dict[7] != 1 || int64Dict[3] != 2 {
+			t.Errorf("Expect tokens ordered by raw token, got %v", int64Dict)
+		}
+	}
+	set := dict.Transform([]uint64{50, 60, 10})
+	if len(set) != 2 || set[0] != 0 || set[1] != 4 {
+		t.Errorf("Expect transformed set [0 4] got %v", set)
+	}
+}
+
+func TestFrequencyOrderTransformFuncOf(t *testing.T) {
+	type point struct{ x, y int }
+	rawSets := [][]point{
+		[]point{{1, 2}, {3, 4}},
+		[]point{{2, 1}, {3, 4}},
+	}
+	// Points with the same frequency are ordered by y.
+	_, dict := FrequencyOrderTransformFuncOf(rawSets, func(a, b point) bool {
+		return a.y < b.y
+	})
+	if dict[point{2, 1}] != 0 || dict[point{1, 2}] != 1 ||
+		dict[point{3, 4}] != 2 {
+		t.Errorf("Expect points ordered by y, got %v", dict)
+	}
+}
+
+func TestNewDictionaryOf(t *testing.T) {
+	_, dict := FrequencyOrderTransformOf([][]uint64{
+		[]uint64{10, 20, 30},
+		[]uint64{20, 30},
+		[]uint64{30},
+	})
+	rawTokens := dict.Tokens()
+	correctRawTokens := []uint64{10, 20, 30}
+	if len(rawTokens) != len(correctRawTokens) {
+		t.Fatalf("Expect raw tokens %v got %v", correctRawTokens, rawTokens)
+	}
+	for i := range correctRawTokens {
+		if rawTokens[i] != correctRawTokens[i] {
+			t.Errorf("Expect raw tokens %v got %v", correctRawTokens, rawTokens)
+		}
+	}
+	newDict, err := NewDictionaryOf(rawTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rawToken, token := range dict {
+		if newDict[rawToken] != token {
+			t.Errorf("Expect %v's token is %v, got %v", rawToken, token,
+				newDict[rawToken])
+		}
+	}
+	if _, err := NewDictionaryOf([]uint64{10, 20, 10}); err == nil {
+		t.Error("Expecting error creating a dictionary with repeated tokens")
+	}
+}
@@ -1,6 +1,9 @@
 package SetSimilaritySearch
 
-import "testing"
+import (
+	"sort"
+	"testing"
+)
 
 func TestTransform(t *testing.T) {
 	rawSets := [][]string{
@@ -52,3 +55,164 @@ func TestTransform(t *testing.T) {
 		t.Errorf("Expect transformed set %v got %v", correctSet, set)
 	}
 }
+
+func TestTransformRepeatedTokens(t *testing.T) {
+	rawSets := [][]string{
+		[]string{"a", "b", "a"},
+		[]string{"b", "c"},
+	}
+	sets, dict := FrequencyOrderTransform(rawSets)
+	if len(sets[0]) != 2 {
+		t.Errorf("Expect repeated tokens removed, got %v", sets[0])
+	}
+	if dict["b"] <= dict["a"] {
+		t.Errorf("Expect a repeated token counted once, got %v", dict)
+	}
+	if set := dict.Transform([]string{"c", "a", "c"}); len(set) != 2 {
+		t.Errorf("Expect repeated tokens removed, got %v", set)
+	}
+}
+
+func TestMultisetTransform(t *testing.T) {
+	rawSets := [][]string{
+		[]string{"a", "b", "a", "a"},
+		[]string{"a", "a", "c"},
+		[]string{"b", "b"},
+	}
+	sets, dict := MultisetFrequencyOrderTransform(rawSets)
+	for i, rawSet := range rawSets {
+		if len(sets[i]) != len(rawSet) {
+			t.Errorf("Expect a token for each occurrence in %v, got %v",
+				rawSet, sets[i])
+		}
+	}
+	// The multiset Jaccard is the sum of the minimum counts divided by the
+	// sum of the maximum counts.
+	if sim := jaccard(sets[0], sets[1]); sim != 2.0/5.0 {
+		t.Errorf("Expect multiset Jaccard 0.4, got %v", sim)
+	}
+	if sim := containment(sets[2], sets[0]); sim != 0.5 {
+		t.Errorf("Expect multiset containment 0.5, got %v", sim)
+	}
+	// Occurrences with the same frequency are ordered by raw token and
+	// occurrence.
+	if dict[MultisetToken{"a", 3}] != 0 || dict[MultisetToken{"b", 2}] != 1 ||
+		dict[MultisetToken{"c", 1}] != 2 {
+		t.Errorf("Expect occurrences ordered by raw token, got %v", dict)
+	}
+	set := dict.Transform([]string{"a", "c", "a", "c"})
+	correctSet := []int{
+		dict[MultisetToken{"a", 1}],
+		dict[MultisetToken{"a", 2}],
+		dict[MultisetToken{"c", 1}],
+	}
+	sort.Ints(correctSet)
+	if len(set) != len(correctSet) {
+		t.Fatalf("Expect transformed set %v got %v", correctSet, set)
+	}
+	for i := range set {
+		if set[i] != correctSet[i] {
+			t.Errorf("Expect transformed set %v got %v", correctSet, set)
+		}
+	}
+}
+
+func TestTransformKeepUnknown(t *testing.T) {
+	_, dict := FrequencyOrderTransform([][]string{
+		[]string{"a", "b"},
+		[]string{"b", "c"},
+	})
+	set, unknown := dict.TransformKeepUnknown([]string{"x", "a", "y", "x"})
+	correctSet := []int{dict["a"], 3, 4}
+	if len(set) != len(correctSet) {
+		t.Fatalf("Expect transformed set %v got %v", correctSet, set)
+	}
+	for i := range set {
+		if set[i] != correctSet[i] {
+			t.Errorf("Expect transformed set %v got %v", correctSet, set)
+		}
+	}
+	if len(unknown) != 2 || unknown[0] != "x" || unknown[1] != "y" {
+		t.Errorf("Expect unknown tokens [x y], got %v", unknown)
+	}
+	// The similarity uses the size of the whole query set.
+	searchIndex, err := NewSearchIndex([][]int{dict.Transform([]string{"a", "b"})},
+		"jaccard", 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := searchIndex.Query(set)
+	if len(results) != 1 || results[0].Similarity != 0.25 {
+		t.Errorf("Expect a result with similarity 0.25, got %v", results)
+	}
+}
+
+func TestTransformDeterministic(t *testing.T) {
+	rawSets := [][]string{
+		[]string{"d", "b", "a"},
+		[]string{"c", "a"},
+	}
+	// Tokens with the same frequency are ordered by the raw tokens.
+	correctTokens := []string{"b", "c", "d", "a"}
+	for i := 0; i < 10; i++ {
+		_, dict := FrequencyOrderTransform(rawSets)
+		tokens := dict.Tokens()
+		for j := range correctTokens {
+			if tokens[j] != correctTokens[j] {
+				t.Fatalf("Expect token order %v, got %v", correctTokens,
+					tokens)
+			}
+		}
+	}
+	// Order the tokens with the same frequency in reverse.
+	_, dict := FrequencyOrderTransformFunc(rawSets, func(a, b string) bool {
+		return a > b
+	})
+	if dict["d"] != 0 || dict["c"] != 1 || dict["b"] != 2 || dict["a"] != 3 {
+		t.Errorf("Expect reverse order of tokens with the same frequency, got %v",
+			dict)
+	}
+	// Reuse an ordering.
+	reused, err := NewDictionary(dict.Tokens())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rawToken, token := range dict {
+		if reused[rawToken] != token {
+			t.Errorf("Expect %v's token is %v, got %v", rawToken, token,
+				reused[rawToken])
+		}
+	}
+	if _, err := NewDictionary([]string{"a", "b", "a"}); err == nil {
+		t.Error("Expect an error for repeated raw tokens")
+	}
+}
+
+func TestReverseDictionary(t *testing.T) {
+	rawSets := [][]string{
+		[]string{"a", "b", "c"},
+		[]string{"a", "b"},
+	}
+	sets, dict := FrequencyOrderTransform(rawSets)
+	rdict := dict.Reverse()
+	if rawToken, exists := rdict.Lookup(dict["b"]); !exists || rawToken != "b" {
+		t.Errorf("Expect b, got %v", rawToken)
+	}
+	if _, exists := rdict.Lookup(len(dict)); exists {
+		t.Error("Expect an unknown integer token not found")
+	}
+	rawSet := rdict.Decode(sets[0])
+	if len(rawSet) != 3 {
+		t.Fatalf("Expect 3 raw tokens, got %v", rawSet)
+	}
+	sort.Strings(rawSet)
+	for i, rawToken := range []string{"a", "b", "c"} {
+		if rawSet[i] != rawToken {
+			t.Errorf("Expect decoded set [a b c], got %v", rawSet)
+		}
+	}
+	set, _ := dict.TransformKeepUnknown([]string{"a", "x"})
+	if rawSet := rdict.Decode(set); len(rawSet) != 1 || rawSet[0] != "a" {
+		t.Errorf("Expect decoded set [a], got %v", rawSet)
+	}
+}
@@ -0,0 +1,114 @@
+package SetSimilaritySearch
+
+import (
+	"errors"
+	"math"
+)
+
+// Tversky is the Tversky index of a query set X and an indexed set Y,
+// |X∩Y| / (|X∩Y| + alpha*|X-Y| + beta*|Y-X|).  It is symmetric when alpha
+// equals beta: Jaccard is alpha = beta = 1, Dice is alpha = beta = 0.5, and
+// Containment of X in Y is alpha = 1, beta = 0.
+// It implements SimilarityFunction, PairOverlapThresholder and SizeBounder,
+// so it is used by registering it with RegisterSimilarity.
+type Tversky struct {
+	alpha float64
+	beta  float64
+}
+
+// NewTversky creates a Tversky index with the given weights of the tokens
+// only in the query set (alpha) and only in the indexed set (beta).
+// It returns an error if a weight is negative or not finite, or if both
+// weights are zero, in which case the similarity is 1 for any overlap, so
+// the prefixes cannot be bounded.
+// Register it under a name including alpha and beta, as saved search indexes
+// are checked only by the name.
+func NewTversky(alpha, beta float64) (*Tversky, error) {
+	if math.IsNaN(alpha) || math.IsInf(alpha, 0) ||
+		math.IsNaN(beta) || math.IsInf(beta, 0) {
+		return nil, errors.New("input alpha and beta must be finite")
+	}
+	if alpha < 0 || beta < 0 {
+		return nil, errors.New("input alpha and beta must be non-negative")
+	}
+	if alpha == 0 && beta == 0 {
+		return nil, errors.New("input alpha and beta must not both be zero")
+	}
+	return &Tversky{alpha, beta}, nil
+}
+
+// Similarity computes the Tversky index of a query set of size1 and an
+// indexed set of size2 given their overlap.
+func (tv *Tversky) Similarity(overlap, size1, size2 int) float64 {
+	if overlap == 0 {
+		return 0.0
+	}
+	return float64(overlap) / (float64(overlap) +
+		tv.alpha*float64(size1-overlap) + tv.beta*float64(size2-overlap))
+}
+
+// weightedOverlapThreshold returns the minimum overlap o of a set of size x
+// with any set to meet the threshold t, where w is the weight of the tokens
+// only in the set of size x.  The similarity is the highest when the other
+// set has no other tokens, that is o / (o + w*(x-o)) >= t, so
+// o >= t*w*x / (1-t+t*w).
+func weightedOverlapThreshold(x int, t, w float64) int {
+	if w == 0 {
+		return 1
+	}
+	return max(1, int(t*w*float64(x)/(1-t+t*w)))
+}
+
+// OverlapThreshold returns the minimum overlap of a query set of the given
+// size with any indexed set to meet the threshold.
+func (tv *Tversky) OverlapThreshold(size int, threshold float64) int {
+	return weightedOverlapThreshold(size, threshold, tv.alpha)
+}
+
+// IndexOverlapThreshold returns the minimum overlap of an indexed set of the
+// given size with any query set to meet the threshold.
+func (tv *Tversky) IndexOverlapThreshold(size int, threshold float64) int {
+	return weightedOverlapThreshold(size, threshold, tv.beta)
+}
+
+// PairOverlapThreshold returns the minimum overlap of a query set and an
+// indexed set of the given sizes to meet the threshold, which is
+// o >= t*(alpha*size1 + beta*size2) / (1-t+t*alpha+t*beta).
+func (tv *Tversky) PairOverlapThreshold(size1, size2 int,
+	threshold float64) int {
+	t := threshold
+	return overlapCeil(t * (tv.alpha*float64(size1) + tv.beta*float64(size2)) /
+		(1 - t + t*tv.alpha + t*tv.beta))
+}
+
+// PositionFilter returns whether a query set and an indexed set of the
+// given sizes can meet the threshold, given the positions of their first
+// matching token.  The similarity increases with the overlap, which is at
+// most the number of tokens from the first matching token.
+func (tv *Tversky) PositionFilter(size1, size2, position1, position2 int,
+	threshold float64) bool {
+	overlap := min(size1-position1, size2-position2)
+	return tv.Similarity(overlap, size1, size2) >= threshold
+}
+
+// SizeBounds returns the minimum and maximum sizes of the indexed sets that
+// can meet the threshold with a query set of the given size.  The overlap is
+// at most the smaller size, so an indexed set of size y <= x needs
+// y >= t*alpha*x / (1-t+t*alpha), and one of size y >= x needs
+// y <= x*(1-t+t*beta) / (t*beta).
+func (tv *Tversky) SizeBounds(size int, threshold float64) (int, int) {
+	t, x := threshold, float64(size)
+	lower := 0
+	if tv.alpha > 0 {
+		lower = overlapCeil(t * tv.alpha * x / (1 - t + t*tv.alpha))
+	}
+	if t == 0 || tv.beta == 0 {
+		return lower, math.MaxInt
+	}
+	return lower, sizeFloor(x * (1 - t + t*tv.beta) / (t * tv.beta))
+}
+
+// Symmetric returns whether alpha equals beta.
+func (tv *Tversky) Symmetric() bool {
+	return tv.alpha == tv.beta
+}
@@ -0,0 +1,91 @@
+package SetSimilaritySearch
+
+import (
+	"fmt"
+	"testing"
+)
+
+var tverskyTestParameters = [][2]float64{
+	{1, 1},
+	{0.5, 0.5},
+	{1, 0},
+	{0, 1},
+	{0.8, 0.2},
+	{0.3, 2},
+}
+
+func init() {
+	for _, p := range tverskyTestParameters {
+		tv, err := NewTversky(p[0], p[1])
+		if err != nil {
+			panic(err)
+		}
+		RegisterSimilarity(fmt.Sprintf("tversky_%v_%v", p[0], p[1]), tv)
+	}
+}
+
+func TestNewTversky(t *testing.T) {
+	for _, p := range [][2]float64{{-1, 1}, {1, -0.5}, {0, 0}} {
+		if _, err := NewTversky(p[0], p[1]); err == nil {
+			t.Errorf("Expecting an error for alpha %v and beta %v", p[0], p[1])
+		}
+	}
+	tv, err := NewTversky(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim := tv.Similarity(2, 4, 10); sim != containmentOverlap(2, 4, 10) {
+		t.Errorf("Expecting containment %v, got %v",
+			containmentOverlap(2, 4, 10), sim)
+	}
+}
+
+func TestTverskyBounds(t *testing.T) {
+	for _, p := range tverskyTestParameters {
+		tv, _ := NewTversky(p[0], p[1])
+		for _, threshold := range []float64{0, 0.3, 0.6, 0.9, 1} {
+			for x := 1; x <= 20; x++ {
+				lower, upper := tv.SizeBounds(x, threshold)
+				for y := 1; y <= 60; y++ {
+					for o := 1; o <= min(x, y); o++ {
+						if tv.Similarity(o, x, y) < threshold {
+							continue
+						}
+						if y < lower || y > upper {
+							t.Errorf("%v: size %d is outside bounds [%d, %d] for size %d and threshold %v",
+								p, y, lower, upper, x, threshold)
+						}
+						if o < tv.OverlapThreshold(x, threshold) ||
+							o < tv.IndexOverlapThreshold(y, threshold) ||
+							o < tv.PairOverlapThreshold(x, y, threshold) {
+							t.Errorf("%v: overlap %d of sizes %d and %d with threshold %v is below a bound",
+								p, o, x, y, threshold)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSearchIndexTversky(t *testing.T) {
+	sets := randomSets(300, 20, 50, 22)
+	queries := randomSets(50, 20, 50, 23)
+	for _, p := range tverskyTestParameters {
+		tv, _ := NewTversky(p[0], p[1])
+		name := fmt.Sprintf("tversky_%v_%v", p[0], p[1])
+		simFunc := func(s1, s2 []int) float64 {
+			return tv.Similarity(intersectionSize(s1, s2), len(s1), len(s2))
+		}
+		for _, threshold := range []float64{0.5, 0.8} {
+			searchIndex, err := NewSearchIndex(sets, name, threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, query := range queries {
+				checkResults(t, searchIndex.Query(query),
+					bruteForceQuery(sets, nil, query, simFunc, threshold))
+			}
+		}
+	}
+}
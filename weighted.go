@@ -0,0 +1,442 @@
+package SetSimilaritySearch
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+)
+
+// WeightedToken is a transformed token with a weight, such as its inverse
+// document frequency.
+type WeightedToken struct {
+	Token  int
+	Weight float64
+}
+
+// WeightedSet is a transformed set of weighted tokens sorted by token.
+type WeightedSet []WeightedToken
+
+// weightedEpsilon is the relative error allowed in the weighted bounds, so
+// floating point errors never make a bound tighter than the exact one.
+const weightedEpsilon = 1e-9
+
+// weightedFunction computes the similarity of two weighted sets.
+type weightedFunction func(WeightedSet, WeightedSet) float64
+
+// WeightedJaccard computes the weighted Jaccard similarity of two weighted
+// sets, which is the sum of the minimum weights of the tokens divided by the
+// sum of the maximum weights.
+func weightedJaccard(s1, s2 WeightedSet) float64 {
+	var i, j int
+	var w1, w2, overlap float64
+	for _, t := range s1 {
+		w1 += t.Weight
+	}
+	for _, t := range s2 {
+		w2 += t.Weight
+	}
+	for i < len(s1) && j < len(s2) {
+		switch d := s1[i].Token - s2[j].Token; {
+		case d == 0:
+			overlap += math.Min(s1[i].Weight, s2[j].Weight)
+			i++
+			j++
+		case d < 0:
+			i++
+		case d > 0:
+			j++
+		}
+	}
+	if w1+w2 == 0 {
+		return 0.0
+	}
+	return overlap / (w1 + w2 - overlap)
+}
+
+// WeightedCosine computes the cosine similarity of two weighted sets as
+// vectors of weights.
+func weightedCosine(s1, s2 WeightedSet) float64 {
+	var i, j int
+	var n1, n2, dot float64
+	for _, t := range s1 {
+		n1 += t.Weight * t.Weight
+	}
+	for _, t := range s2 {
+		n2 += t.Weight * t.Weight
+	}
+	for i < len(s1) && j < len(s2) {
+		switch d := s1[i].Token - s2[j].Token; {
+		case d == 0:
+			dot += s1[i].Weight * s2[j].Weight
+			i++
+			j++
+		case d < 0:
+			i++
+		case d > 0:
+			j++
+		}
+	}
+	if n1 == 0 || n2 == 0 {
+		return 0.0
+	}
+	return dot / math.Sqrt(n1*n2)
+}
+
+// weightedMassFunction returns the mass of a token with the given weight.
+// The mass of the tokens shared by two sets bounds their similarity, so the
+// prefix of a set is determined by the cumulative mass of its tokens.
+type weightedMassFunction func(float64) float64
+
+func jaccardMass(w float64) float64 { return w }
+
+func cosineMass(w float64) float64 { return w * w }
+
+// weightedOverlapThresholdFunction takes the total mass of a set and a
+// similarity threshold, and returns the minimum mass of the tokens the set
+// shares with any set to meet the threshold.
+type weightedOverlapThresholdFunction func(float64, float64) float64
+
+// The sum of the minimum weights is at most the weight of the shared tokens
+// in either set, and the sum of the maximum weights is at least the weight
+// of either set.
+func weightedJaccardOverlapThresholdFunc(m, t float64) float64 {
+	return t * m * (1 - weightedEpsilon)
+}
+
+// By Cauchy-Schwarz, the cosine similarity is at most the norm of the shared
+// tokens in either set divided by the norm of the set.
+func weightedCosineOverlapThresholdFunc(m, t float64) float64 {
+	return t * t * m * (1 - weightedEpsilon)
+}
+
+// weightedPositionFilter takes the total masses of two sets and the masses
+// from their first matching token to the end, and returns whether the sets
+// can still meet the similarity threshold.
+type weightedPositionFilter func(float64, float64, float64, float64, float64) bool
+
+func weightedJaccardPositionFilter(m1, m2, rest1, rest2, t float64) bool {
+	return math.Min(rest1, rest2) >= t*math.Max(m1, m2)*(1-weightedEpsilon)
+}
+
+func weightedCosinePositionFilter(m1, m2, rest1, rest2, t float64) bool {
+	return rest1*rest2 >= t*t*m1*m2*(1-weightedEpsilon)
+}
+
+// weightedSizeBoundsFunction takes the total mass of a query set and a
+// similarity threshold, and returns the minimum and maximum masses of the
+// sets that can meet the threshold with the query set.
+type weightedSizeBoundsFunction func(float64, float64) (float64, float64)
+
+func weightedJaccardSizeBounds(m, t float64) (float64, float64) {
+	if t == 0 {
+		return 0, math.Inf(1)
+	}
+	return t * m * (1 - weightedEpsilon), m / t * (1 + weightedEpsilon)
+}
+
+// The cosine similarity does not depend on the norms.
+func weightedCosineSizeBounds(m, t float64) (float64, float64) {
+	return 0, math.Inf(1)
+}
+
+var weightedSimilarityFuncs = map[string]weightedFunction{
+	"jaccard": weightedJaccard,
+	"cosine":  weightedCosine,
+}
+
+var weightedMassFuncs = map[string]weightedMassFunction{
+	"jaccard": jaccardMass,
+	"cosine":  cosineMass,
+}
+
+var weightedOverlapThresholdFuncs = map[string]weightedOverlapThresholdFunction{
+	"jaccard": weightedJaccardOverlapThresholdFunc,
+	"cosine":  weightedCosineOverlapThresholdFunc,
+}
+
+var weightedPositionFilterFuncs = map[string]weightedPositionFilter{
+	"jaccard": weightedJaccardPositionFilter,
+	"cosine":  weightedCosinePositionFilter,
+}
+
+var weightedSizeBoundsFuncs = map[string]weightedSizeBoundsFunction{
+	"jaccard": weightedJaccardSizeBounds,
+	"cosine":  weightedCosineSizeBounds,
+}
+
+// weightedPostingListEntry is a token in the prefix of an indexed weighted
+// set, with the total mass of the set and the mass from the token to the end.
+type weightedPostingListEntry struct {
+	setIndex      int
+	tokenPosition int
+	mass          float64
+	rest          float64
+}
+
+// weightedJoin holds the similarity functions and the masses of weighted
+// sets, and is shared by AllPairsWeighted and WeightedSearchIndex.
+type weightedJoin struct {
+	threshold            float64
+	simFunc              weightedFunction
+	massFunc             weightedMassFunction
+	overlapThresholdFunc weightedOverlapThresholdFunction
+	positionFilterFunc   weightedPositionFilter
+	sizeBoundsFunc       weightedSizeBoundsFunction
+}
+
+func newWeightedJoin(similarityFunctionName string,
+	similarityThreshold float64) (*weightedJoin, error) {
+	if similarityThreshold < 0 || similarityThreshold > 1.0 {
+		return nil, errors.New("input similarityThreshold must be in the range [0, 1]")
+	}
+	simFunc, exists := weightedSimilarityFuncs[similarityFunctionName]
+	if !exists {
+		return nil, errors.New("input similarityFunctionName does not exist for weighted sets")
+	}
+	return &weightedJoin{
+		threshold:            similarityThreshold,
+		simFunc:              simFunc,
+		massFunc:             weightedMassFuncs[similarityFunctionName],
+		overlapThresholdFunc: weightedOverlapThresholdFuncs[similarityFunctionName],
+		positionFilterFunc:   weightedPositionFilterFuncs[similarityFunctionName],
+		sizeBoundsFunc:       weightedSizeBoundsFuncs[similarityFunctionName],
+	}, nil
+}
+
+// checkWeightedSet returns an error if the tokens of a weighted set are not
+// sorted and unique, or a weight is not positive.
+func checkWeightedSet(s WeightedSet) error {
+	for i, t := range s {
+		if i > 0 && t.Token <= s[i-1].Token {
+			return errors.New("input weighted set tokens must be sorted and unique")
+		}
+		if !(t.Weight > 0) || math.IsInf(t.Weight, 0) {
+			return errors.New("input weighted set weights must be positive and finite")
+		}
+	}
+	return nil
+}
+
+// rests returns the masses from each token of a weighted set to the end,
+// so the first one is the total mass of the set.
+func (j *weightedJoin) rests(s WeightedSet) []float64 {
+	rests := make([]float64, len(s)+1)
+	for i := len(s) - 1; i >= 0; i-- {
+		rests[i] = rests[i+1] + j.massFunc(s[i].Weight)
+	}
+	return rests[:len(s)]
+}
+
+// prefixSize returns the number of tokens in the prefix of a weighted set,
+// so the mass of the tokens after the prefix is less than the overlap
+// threshold, and any set meeting the threshold shares a token in the prefix.
+func (j *weightedJoin) prefixSize(rests []float64) int {
+	if len(rests) == 0 {
+		return 0
+	}
+	t := j.overlapThresholdFunc(rests[0], j.threshold)
+	for p := 1; p < len(rests); p++ {
+		if rests[p] < t {
+			return p
+		}
+	}
+	return len(rests)
+}
+
+// index inserts the tokens in the prefix of the set x into the posting lists.
+func (j *weightedJoin) index(x int, s WeightedSet, rests []float64,
+	postingLists map[int][]weightedPostingListEntry) {
+	for p, t := range s[:j.prefixSize(rests)] {
+		postingLists[t.Token] = append(postingLists[t.Token],
+			weightedPostingListEntry{x, p, rests[0], rests[p]})
+	}
+}
+
+// probe finds the sets in the posting lists meeting the threshold with the
+// set s, whose entries are sorted by mass.  The candidates passing the
+// filters are verified by calling verify, which returns the similarity.
+func (j *weightedJoin) probe(s WeightedSet, rests []float64,
+	postingLists map[int][]weightedPostingListEntry,
+	verify func(x int) float64) []SearchResult {
+	results := make([]SearchResult, 0)
+	if len(s) == 0 {
+		return results
+	}
+	lower, upper := j.sizeBoundsFunc(rests[0], j.threshold)
+	candidates := make(map[int]bool)
+	var order []int
+	for p1, t := range s[:j.prefixSize(rests)] {
+		postingList := postingLists[t.Token]
+		// Skip the sets outside the size bounds.
+		start := sort.Search(len(postingList), func(i int) bool {
+			return postingList[i].mass >= lower
+		})
+		for _, entry := range postingList[start:] {
+			if entry.mass > upper {
+				break
+			}
+			x2 := entry.setIndex
+			if _, seen := candidates[x2]; seen {
+				continue
+			}
+			passes := j.positionFilterFunc(rests[0], entry.mass, rests[p1],
+				entry.rest, j.threshold)
+			candidates[x2] = passes
+			if passes {
+				order = append(order, x2)
+			}
+		}
+	}
+	for _, x2 := range order {
+		if sim := verify(x2); sim >= j.threshold {
+			results = append(results, SearchResult{x2, sim})
+		}
+	}
+	return results
+}
+
+// AllPairsWeighted is the same as AllPairs, but finds all pairs of weighted
+// sets with similarity greater than a threshold.  The prefix of each set is
+// determined by the cumulative weight of its tokens rather than their
+// number, so the tokens with high weights should be ordered first, as
+// rare tokens are by FrequencyOrderTransform.
+// Currently supported similarity functions are "jaccard" and "cosine",
+// which are the weighted Jaccard and cosine similarities.
+// Each pair is found once with X > Y.
+func AllPairsWeighted(sets []WeightedSet, similarityFunctionName string,
+	similarityThreshold float64) (<-chan Pair, error) {
+	pairs, _, err := AllPairsWeightedContext(context.Background(), sets,
+		similarityFunctionName, similarityThreshold)
+	return pairs, err
+}
+
+// AllPairsWeightedContext is the same as AllPairsWeighted, but stops when the
+// context is done.  The returned error channel works the same way as the
+// one returned by AllPairsContext.
+func AllPairsWeightedContext(ctx context.Context, sets []WeightedSet,
+	similarityFunctionName string, similarityThreshold float64) (<-chan Pair,
+	<-chan error, error) {
+	if len(sets) == 0 {
+		return nil, nil, errors.New("input sets mut be a non-empty slice")
+	}
+	for _, s := range sets {
+		if err := checkWeightedSet(s); err != nil {
+			return nil, nil, err
+		}
+	}
+	j, err := newWeightedJoin(similarityFunctionName, similarityThreshold)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := make(chan Pair)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(out)
+		errc <- j.allPairs(ctx, sets, out)
+	}()
+	return out, errc, nil
+}
+
+// allPairs probes and then indexes the sets in the order of their masses,
+// so the posting lists are sorted by mass.
+func (j *weightedJoin) allPairs(ctx context.Context, sets []WeightedSet,
+	out chan<- Pair) error {
+	allRests := make([][]float64, len(sets))
+	for x, s := range sets {
+		allRests[x] = j.rests(s)
+	}
+	mass := func(x int) float64 {
+		if len(allRests[x]) == 0 {
+			return 0
+		}
+		return allRests[x][0]
+	}
+	indexes := make([]int, len(sets))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, k int) bool {
+		return mass(indexes[i]) < mass(indexes[k])
+	})
+	postingLists := make(map[int][]weightedPostingListEntry)
+	var pairs []Pair
+	for _, x1 := range indexes {
+		s1 := sets[x1]
+		results := j.probe(s1, allRests[x1], postingLists, func(x2 int) float64 {
+			return j.simFunc(s1, sets[x2])
+		})
+		pairs = pairs[:0]
+		for _, r := range results {
+			if x1 > r.X {
+				pairs = append(pairs, Pair{x1, r.X, r.Similarity})
+			} else {
+				pairs = append(pairs, Pair{r.X, x1, r.Similarity})
+			}
+		}
+		if err := sendPairs(ctx, out, pairs); err != nil {
+			return err
+		}
+		j.index(x1, s1, allRests[x1], postingLists)
+	}
+	return nil
+}
+
+// WeightedSearchIndex is the same as SearchIndex, but for weighted sets.
+// It is read-only once built, so it is safe for concurrent use by multiple
+// goroutines.
+type WeightedSearchIndex struct {
+	join         *weightedJoin
+	sets         []WeightedSet
+	postingLists map[int][]weightedPostingListEntry
+}
+
+// NewWeightedSearchIndex builds a search index on the weighted sets given
+// the similarity function and threshold.  The prefixes are determined the
+// same way as AllPairsWeighted does.
+// Currently supported similarity functions are "jaccard" and "cosine".
+func NewWeightedSearchIndex(sets []WeightedSet, similarityFunctionName string,
+	similarityThreshold float64) (*WeightedSearchIndex, error) {
+	if len(sets) == 0 {
+		return nil, errors.New("input sets cannot be empty")
+	}
+	for _, s := range sets {
+		if err := checkWeightedSet(s); err != nil {
+			return nil, err
+		}
+	}
+	j, err := newWeightedJoin(similarityFunctionName, similarityThreshold)
+	if err != nil {
+		return nil, err
+	}
+	si := &WeightedSearchIndex{
+		join:         j,
+		sets:         sets,
+		postingLists: make(map[int][]weightedPostingListEntry),
+	}
+	for x, s := range sets {
+		j.index(x, s, j.rests(s), si.postingLists)
+	}
+	// Sort each posting list by set mass for the size bounds.
+	for _, postingList := range si.postingLists {
+		sort.SliceStable(postingList, func(i, k int) bool {
+			return postingList[i].mass < postingList[k].mass
+		})
+	}
+	return si, nil
+}
+
+// Query probes the search index for sets whose similarities with the query
+// weighted set are above the similarity threshold specified for the index.
+// It returns an error if the query set is not sorted by token or has a
+// non-positive weight.
+func (si *WeightedSearchIndex) Query(s WeightedSet) ([]SearchResult, error) {
+	if err := checkWeightedSet(s); err != nil {
+		return nil, err
+	}
+	j := si.join
+	return j.probe(s, j.rests(s), si.postingLists, func(x int) float64 {
+		return j.simFunc(s, si.sets[x])
+	}), nil
+}
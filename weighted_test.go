@@ -0,0 +1,114 @@
+package SetSimilaritySearch
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+)
+
+func randomWeightedSets(n, maxSize, numTokens int, seed int64) []WeightedSet {
+	r := rand.New(rand.NewSource(seed))
+	sets := make([]WeightedSet, n)
+	for i, s := range randomSets(n, maxSize, numTokens, seed) {
+		sets[i] = make(WeightedSet, len(s))
+		for j, token := range s {
+			// Rare tokens first with higher weights, as IDF weights are.
+			weight := 0.5 + r.Float64() + float64(numTokens-token)/10
+			sets[i][j] = WeightedToken{token, weight}
+		}
+	}
+	return sets
+}
+
+func TestAllPairsWeighted(t *testing.T) {
+	sets := randomWeightedSets(300, 20, 50, 24)
+	for function, simFunc := range weightedSimilarityFuncs {
+		for _, threshold := range []float64{0.3, 0.5, 0.8} {
+			correctPairs := make(map[Pair]bool)
+			for x1 := range sets {
+				for x2 := 0; x2 < x1; x2++ {
+					sim := simFunc(sets[x1], sets[x2])
+					if sim > 0 && sim >= threshold {
+						correctPairs[Pair{x1, x2, sim}] = true
+					}
+				}
+			}
+			if len(correctPairs) == 0 {
+				t.Fatalf("Expecting some %s pairs with threshold %v in the test input",
+					function, threshold)
+			}
+			pairs, err := AllPairsWeighted(sets, function, threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := collectPairs(pairs)
+			for p := range found {
+				if !correctPairs[p] {
+					t.Errorf("The %s pair %v is not correct", function, p)
+				}
+			}
+			if len(found) != len(correctPairs) {
+				t.Errorf("Expecting %d %s pairs with threshold %v but found %d",
+					len(correctPairs), function, threshold, len(found))
+			}
+		}
+	}
+	// The error channel is closed after the error is sent.
+	pairs, errc, err := AllPairsWeightedContext(context.Background(),
+		randomWeightedSets(50, 10, 20, 1), "jaccard", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collectPairs(pairs)
+	for err := range errc {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := AllPairsWeighted([]WeightedSet{{{2, 1}, {1, 1}}}, "jaccard",
+		0.5); err == nil {
+		t.Error("Expecting an error for unsorted tokens")
+	}
+	if _, err := AllPairsWeighted([]WeightedSet{{{1, 0}}}, "jaccard",
+		0.5); err == nil {
+		t.Error("Expecting an error for a zero weight")
+	}
+}
+
+func TestWeightedSearchIndex(t *testing.T) {
+	sets := randomWeightedSets(300, 20, 50, 25)
+	queries := randomWeightedSets(50, 20, 50, 26)
+	for function, simFunc := range weightedSimilarityFuncs {
+		for _, threshold := range []float64{0.3, 0.5, 0.8} {
+			searchIndex, err := NewWeightedSearchIndex(sets, function, threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, query := range queries {
+				correctResults := make([]SearchResult, 0)
+				for x, s := range sets {
+					sim := simFunc(query, s)
+					if sim > 0 && sim >= threshold {
+						correctResults = append(correctResults,
+							SearchResult{x, sim})
+					}
+				}
+				results, err := searchIndex.Query(query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkResults(t, results, correctResults)
+			}
+		}
+	}
+	searchIndex, err := NewWeightedSearchIndex(sets, "jaccard", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := searchIndex.Query(WeightedSet{{2, 1}, {1, 1}}); err == nil {
+		t.Error("Expecting an error for an unsorted query")
+	}
+	if _, err := NewWeightedSearchIndex(nil, "jaccard", 0.5); err == nil {
+		t.Error("Expecting an error for empty sets")
+	}
+}